@@ -0,0 +1,360 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/internal/hooks"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// List of errors
+var (
+	ErrChecksum = errors.New("archive: checksum mismatch")
+	ErrNotFound = errors.New("archive: manifest not found")
+)
+
+// Config holds the source collection and the destination of an Archiver.
+// Zero values fall back to the defaults documented on each field.
+type Config struct {
+	// Database holding the audit collections, required
+	Database *mongo.Database
+	// Collection holds the audit logs, "audit_logs" by default
+	Collection string
+	// ManifestCollection records every archive, "audit_archives" by default
+	ManifestCollection string
+	// Store keeps the archive files, required
+	Store Store
+	// Prefix is prepended to the name of every archive file, "audit_logs/" by default
+	Prefix string
+	// BatchSize is the number of audit logs deleted or rehydrated per request, 1000 by default
+	BatchSize int
+	// LegalHold exempts audit logs from archival whatever their age, like it does from purges,
+	// see gaudit.LegalHold
+	LegalHold hooks.LegalHold
+	Logger    *slog.Logger
+}
+
+// Manifest describes an archive file, it is recorded in the manifest collection and stored
+// next to the archive file as <name>.manifest.json
+type Manifest struct {
+	Id primitive.ObjectID `json:"id" bson:"_id"`
+	// Name of the archive file in the store
+	Name string `json:"name" bson:"name"`
+	// Cutoff is the date before which audit logs were archived
+	Cutoff time.Time `json:"cutoff" bson:"cutoff"`
+	// From and To are the creation dates of the oldest and newest archived audit logs
+	From  time.Time `json:"from" bson:"from"`
+	To    time.Time `json:"to" bson:"to"`
+	Count int64     `json:"count" bson:"count"`
+	// SHA256 is the hex checksum of the archive file
+	SHA256    string    `json:"sha256" bson:"sha256"`
+	Size      int64     `json:"size" bson:"size"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Archiver moves aged audit logs to gzip compressed JSONL files and back
+type Archiver struct {
+	cfg Config
+}
+
+// New returns an Archiver for cfg
+func New(cfg Config) (*Archiver, error) {
+	if cfg.Database == nil || cfg.Store == nil {
+		return nil, errors.New("archive: database and store are required")
+	}
+	if cfg.Collection == "" {
+		cfg.Collection = "audit_logs"
+	}
+	if cfg.ManifestCollection == "" {
+		cfg.ManifestCollection = "audit_archives"
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "audit_logs/"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Archiver{cfg: cfg}, nil
+}
+
+// Archive moves the audit logs created before cutoff that aren't on legal hold to a new
+// archive file. The audit logs are deleted once the file is stored and its manifest recorded.
+// It returns nil when there is nothing to archive.
+func (a *Archiver) Archive(ctx context.Context, cutoff time.Time) (*Manifest, error) {
+	cursor, err := a.cfg.Database.Collection(a.cfg.Collection).Find(ctx, a.filter(cutoff),
+		options.Find().SetSort(bson.D{{Key: "audit_created_at", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("error finding audit logs: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	tmp, err := os.CreateTemp("", "gaudit-archive-*.jsonl.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := newWriter(tmp)
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var log in.AuditLog
+		if err := cursor.Decode(&log); err != nil {
+			return nil, fmt.Errorf("error decoding audit log: %w", err)
+		}
+		if err := w.add(log); err != nil {
+			return nil, err
+		}
+		ids = append(ids, log.Id)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	manifest, err := w.close()
+	if err != nil {
+		return nil, err
+	}
+	manifest.Id = primitive.NewObjectID()
+	manifest.Cutoff = cutoff
+	manifest.CreatedAt = time.Now()
+	manifest.Name = fmt.Sprintf("%s%s_%s_%s.jsonl.gz", a.cfg.Prefix,
+		manifest.From.UTC().Format("20060102T150405Z"), manifest.To.UTC().Format("20060102T150405Z"), manifest.Id.Hex())
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := a.cfg.Store.Put(ctx, manifest.Name, tmp); err != nil {
+		return nil, fmt.Errorf("error storing %s: %w", manifest.Name, err)
+	}
+	sidecar, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := a.cfg.Store.Put(ctx, manifest.Name+".manifest.json", bytes.NewReader(sidecar)); err != nil {
+		return nil, fmt.Errorf("error storing the manifest of %s: %w", manifest.Name, err)
+	}
+	if _, err := a.cfg.Database.Collection(a.cfg.ManifestCollection).InsertOne(ctx, manifest); err != nil {
+		return nil, fmt.Errorf("error recording the manifest of %s: %w", manifest.Name, err)
+	}
+
+	for start := 0; start < len(ids); start += a.cfg.BatchSize {
+		end := min(start+a.cfg.BatchSize, len(ids))
+		_, err := a.cfg.Database.Collection(a.cfg.Collection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids[start:end]}})
+		if err != nil {
+			return &manifest, fmt.Errorf("error deleting archived audit logs: %w", err)
+		}
+	}
+	return &manifest, nil
+}
+
+// filter matches the audit logs to archive at cutoff
+func (a *Archiver) filter(cutoff time.Time) bson.M {
+	return a.cfg.LegalHold.Exclude(bson.M{"audit_created_at": bson.M{"$lt": cutoff}})
+}
+
+// Run archives the audit logs older than age every interval until ctx is done
+func (a *Archiver) Run(ctx context.Context, age, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		manifest, err := a.Archive(ctx, time.Now().Add(-age))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			a.cfg.Logger.Error(fmt.Sprintf("Failed to archive audit logs: %v", err))
+		case manifest != nil:
+			a.cfg.Logger.Info(fmt.Sprintf("Archived %d audit logs to %s", manifest.Count, manifest.Name))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Manifests returns the recorded archives, oldest first
+func (a *Archiver) Manifests(ctx context.Context) ([]Manifest, error) {
+	cursor, err := a.cfg.Database.Collection(a.cfg.ManifestCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "from", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []Manifest
+	if err := cursor.All(ctx, &manifests); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// Rehydrate loads the audit logs of the archive file name into collection target, e.g. for an
+// investigation. The file is checked against its manifest before anything is inserted.
+func (a *Archiver) Rehydrate(ctx context.Context, name, target string) (int64, error) {
+	var manifest Manifest
+	err := a.cfg.Database.Collection(a.cfg.ManifestCollection).FindOne(ctx, bson.M{"name": name}).Decode(&manifest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp("", "gaudit-rehydrate-*.jsonl.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := a.download(ctx, manifest, tmp); err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	col := a.cfg.Database.Collection(target)
+	batch := make([]interface{}, 0, a.cfg.BatchSize)
+	var count int64
+	insert := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := col.InsertMany(ctx, batch); err != nil {
+			return fmt.Errorf("error rehydrating %s: %w", name, err)
+		}
+		count += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	err = Read(tmp, func(log in.AuditLog) error {
+		batch = append(batch, log)
+		if len(batch) < a.cfg.BatchSize {
+			return nil
+		}
+		return insert()
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, insert()
+}
+
+// download copies the archive file of manifest to w and checks its checksum
+func (a *Archiver) download(ctx context.Context, manifest Manifest, w io.Writer) error {
+	r, err := a.cfg.Store.Get(ctx, manifest.Name)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %w", manifest.Name, err)
+	}
+	defer r.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), r); err != nil {
+		return fmt.Errorf("error fetching %s: %w", manifest.Name, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != manifest.SHA256 {
+		return fmt.Errorf("%w: %s is %s, expected %s", ErrChecksum, manifest.Name, sum, manifest.SHA256)
+	}
+	return nil
+}
+
+// Read decodes the audit logs of an archive file and calls fn for each of them
+func Read(r io.Reader, fn func(log in.AuditLog) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		var log in.AuditLog
+		err := dec.Decode(&log)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding archive: %w", err)
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+}
+
+// writer encodes audit logs as gzip compressed JSONL and computes the manifest of the file
+type writer struct {
+	counter  *countingWriter
+	hash     hash.Hash
+	gz       *gzip.Writer
+	buf      *bufio.Writer
+	enc      *json.Encoder
+	manifest Manifest
+}
+
+func newWriter(w io.Writer) *writer {
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, hash)}
+	gz := gzip.NewWriter(counter)
+	buf := bufio.NewWriter(gz)
+	return &writer{counter: counter, hash: hash, gz: gz, buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (w *writer) add(log in.AuditLog) error {
+	if err := w.enc.Encode(log); err != nil {
+		return fmt.Errorf("error encoding audit log %s: %w", log.Id.Hex(), err)
+	}
+	if log.AuditCreatedAt != nil {
+		if w.manifest.From.IsZero() || log.AuditCreatedAt.Before(w.manifest.From) {
+			w.manifest.From = *log.AuditCreatedAt
+		}
+		if log.AuditCreatedAt.After(w.manifest.To) {
+			w.manifest.To = *log.AuditCreatedAt
+		}
+	}
+	w.manifest.Count++
+	return nil
+}
+
+// close flushes the file and returns its manifest, without id, name and dates
+func (w *writer) close() (Manifest, error) {
+	if err := w.buf.Flush(); err != nil {
+		return Manifest{}, err
+	}
+	if err := w.gz.Close(); err != nil {
+		return Manifest{}, err
+	}
+	w.manifest.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	w.manifest.Size = w.counter.n
+	return w.manifest, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
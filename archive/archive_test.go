@@ -0,0 +1,146 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/internal/hooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLog(createdAt time.Time) in.AuditLog {
+	return in.AuditLog{
+		Id:             primitive.NewObjectID(),
+		Collection:     "user",
+		Operation:      "update",
+		DocumentId:     "42",
+		AuditCreatedAt: &createdAt,
+		Change:         map[string]in.AuditChange{"name": {Old: "John", New: "Jane"}},
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	first := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	logs := []in.AuditLog{newLog(first.Add(time.Hour)), newLog(first), newLog(first.Add(2 * time.Hour))}
+
+	var file bytes.Buffer
+	w := newWriter(&file)
+	for _, log := range logs {
+		require.NoError(t, w.add(log))
+	}
+	manifest, err := w.close()
+	require.NoError(t, err)
+
+	sum := sha256.Sum256(file.Bytes())
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest.SHA256)
+	assert.EqualValues(t, file.Len(), manifest.Size)
+	assert.EqualValues(t, 3, manifest.Count)
+	assert.Equal(t, first, manifest.From)
+	assert.Equal(t, first.Add(2*time.Hour), manifest.To)
+
+	var got []in.AuditLog
+	require.NoError(t, Read(&file, func(log in.AuditLog) error {
+		got = append(got, log)
+		return nil
+	}))
+	require.Len(t, got, 3)
+	for i := range logs {
+		assert.Equal(t, logs[i].Id, got[i].Id)
+		assert.Equal(t, logs[i].Change, got[i].Change)
+		assert.True(t, logs[i].AuditCreatedAt.Equal(*got[i].AuditCreatedAt))
+	}
+}
+
+func TestArchiver_filter(t *testing.T) {
+	cutoff := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &Archiver{}
+	assert.Equal(t, bson.M{"audit_created_at": bson.M{"$lt": cutoff}}, a.filter(cutoff))
+
+	a.cfg.LegalHold = hooks.LegalHold{Documents: []string{"42"}, Users: []string{"auditor"}}
+	assert.Equal(t, bson.M{
+		"audit_created_at": bson.M{"$lt": cutoff},
+		"document_id":      bson.M{"$nin": []string{"42"}},
+		"user_id":          bson.M{"$nin": []string{"auditor"}},
+	}, a.filter(cutoff), "the audit logs on legal hold are kept")
+}
+
+func TestLocalStore(t *testing.T) {
+	store := LocalStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "audit_logs/a.jsonl.gz", strings.NewReader("archive")))
+
+	r, err := store.Get(ctx, "audit_logs/a.jsonl.gz")
+	require.NoError(t, err)
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(body))
+
+	_, err = store.Get(ctx, "audit_logs/missing.jsonl.gz")
+	assert.Error(t, err)
+}
+
+func TestS3Store(t *testing.T) {
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=minio/") || r.Header.Get("X-Amz-Date") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			sum := sha256.Sum256(body)
+			if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	store := S3Store{Endpoint: srv.URL, Bucket: "audit", AccessKey: "minio", SecretKey: "secret", Prefix: "prod/"}
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "a.jsonl.gz", strings.NewReader("archive")))
+	assert.Contains(t, objects, "/audit/prod/a.jsonl.gz")
+
+	r, err := store.Get(ctx, "a.jsonl.gz")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "archive", string(body))
+
+	_, err = store.Get(ctx, "missing.jsonl.gz")
+	assert.ErrorContains(t, err, "404")
+}
+
+func Test_signingKey(t *testing.T) {
+	// Example of the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
+
+func Test_awsEscape(t *testing.T) {
+	assert.Equal(t, "a-b_c.d~e", awsEscape("a-b_c.d~e"))
+	assert.Equal(t, "a%20b%2Bc%3A", awsEscape("a b+c:"))
+}
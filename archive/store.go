@@ -0,0 +1,45 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps archive files, names are slash separated paths
+type Store interface {
+	Put(ctx context.Context, name string, body io.ReadSeeker) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// LocalStore keeps archive files under a directory of the local file system
+type LocalStore struct {
+	Dir string
+}
+
+func (s LocalStore) Put(_ context.Context, name string, body io.ReadSeeker) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// The file appears complete or not at all
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s LocalStore) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, filepath.FromSlash(name)))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/its-own/gaudit/export"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// stringsFlag collects the values of a repeatable flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runExport(ctx context.Context, e *env, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var filter export.Filter
+	fs.Var((*stringsFlag)(&filter.Collections), "collection", "collection to export, repeatable, all by default")
+	fs.Var((*stringsFlag)(&filter.Operations), "operation", "operation to export, repeatable, all by default")
+	from := fs.String("from", "", "export the audit logs created at or after this RFC 3339 date")
+	to := fs.String("to", "", "export the audit logs created before this RFC 3339 date")
+	format := fs.String("format", "jsonl", "csv, jsonl, cef or leef")
+	out := fs.String("out", "", "output file, stdout by default")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	for _, bound := range []struct {
+		value string
+		at    *time.Time
+	}{{*from, &filter.From}, {*to, &filter.To}} {
+		if bound.value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return fmt.Errorf("%w: %q isn't an RFC 3339 date", errUsage, bound.value)
+		}
+		*bound.at = at
+	}
+	opts := export.Options{Format: export.Format(*format)}
+	if _, err := export.NewEncoder(io.Discard, opts); errors.Is(err, export.ErrFormat) {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := e.connect(ctx); err != nil {
+		return err
+	}
+	w := e.out
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	_, err := export.New(e.logs).Export(ctx, w, filter, opts)
+	return err
+}
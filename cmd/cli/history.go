@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"sort"
+	"time"
+)
+
+func runHistory(ctx context.Context, e *env, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: history takes a collection and a document id", errUsage)
+	}
+	if err := e.connect(ctx); err != nil {
+		return err
+	}
+	logs, err := documentLogs(ctx, e, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("no audit logs for %s %s", args[0], args[1])
+	}
+	printHistory(e.out, logs)
+	return nil
+}
+
+func runDiff(ctx context.Context, e *env, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return fmt.Errorf("%w: diff takes a collection, a document id and one or two points", errUsage)
+	}
+	if err := e.connect(ctx); err != nil {
+		return err
+	}
+	logs, err := documentLogs(ctx, e, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	from, err := parsePoint(args[2])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	to := point{at: time.Now()}
+	if len(args) == 4 {
+		if to, err = parsePoint(args[3]); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+	}
+	for _, p := range []point{from, to} {
+		if !p.logId.IsZero() && !hasLog(logs, p.logId) {
+			return fmt.Errorf("audit log %s isn't part of the history of %s %s", p.logId.Hex(), args[0], args[1])
+		}
+	}
+	printChanges(e.out, netChanges(between(logs, from, to)))
+	return nil
+}
+
+// documentLogs returns the audit logs of a document, oldest first
+func documentLogs(ctx context.Context, e *env, col, id string) ([]in.AuditLog, error) {
+	cursor, err := e.logs.Find(ctx, bson.M{"collection": col, "document_id": id},
+		options.Find().SetSort(bson.D{{Key: "audit_created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var logs []in.AuditLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// point is a position in the history of a document: a date, or an audit log
+type point struct {
+	at    time.Time
+	logId primitive.ObjectID
+}
+
+// parsePoint parses an RFC 3339 date or an audit log id
+func parsePoint(s string) (point, error) {
+	if id, err := primitive.ObjectIDFromHex(s); err == nil {
+		return point{logId: id}, nil
+	}
+	at, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return point{}, fmt.Errorf("%q is neither an RFC 3339 date nor an audit log id", s)
+	}
+	return point{at: at}, nil
+}
+
+// after reports whether log comes after p, a log is at or before its own point
+func (p point) after(log in.AuditLog, index, pointIndex int) bool {
+	if !p.logId.IsZero() {
+		return index > pointIndex
+	}
+	return log.AuditCreatedAt != nil && log.AuditCreatedAt.After(p.at)
+}
+
+// between returns the logs after from, up to and including to
+func between(logs []in.AuditLog, from, to point) []in.AuditLog {
+	indexOf := func(p point) int {
+		for i, log := range logs {
+			if log.Id == p.logId {
+				return i
+			}
+		}
+		return len(logs)
+	}
+	fromIndex, toIndex := indexOf(from), indexOf(to)
+	var out []in.AuditLog
+	for i, log := range logs {
+		if from.after(log, i, fromIndex) && !to.after(log, i, toIndex) {
+			out = append(out, log)
+		}
+	}
+	return out
+}
+
+func hasLog(logs []in.AuditLog, id primitive.ObjectID) bool {
+	for _, log := range logs {
+		if log.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// netChanges folds the changes of logs into one change per field, from its first old value to
+// its last new value. Fields back to their initial value are left out.
+func netChanges(logs []in.AuditLog) map[string]in.AuditChange {
+	changes := make(map[string]in.AuditChange)
+	for _, log := range logs {
+		for field, change := range log.Change {
+			net, ok := changes[field]
+			if !ok {
+				net.Old = change.Old
+			}
+			net.New = change.New
+			changes[field] = net
+		}
+	}
+	for field, change := range changes {
+		if change.Old == change.New {
+			delete(changes, field)
+		}
+	}
+	return changes
+}
+
+func printHistory(w io.Writer, logs []in.AuditLog) {
+	for _, log := range logs {
+		at := "-"
+		if log.AuditCreatedAt != nil {
+			at = log.AuditCreatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s  %-6s  user=%s (%s)  log=%s\n", at, log.Operation, log.UserID, log.UserType, log.Id.Hex())
+		printChanges(w, log.Change)
+	}
+}
+
+func printChanges(w io.Writer, changes map[string]in.AuditChange) {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Fprintf(w, "    %s: %q -> %q\n", field, changes[field].Old, changes[field].New)
+	}
+}
@@ -0,0 +1,144 @@
+// Command gaudit inspects and exports the audit trail written by gaudit.
+//
+//	gaudit [-config config.yaml] <command> [flags] [args]
+//
+// Commands:
+//
+//	history <collection> <id>               print the change timeline of a document
+//	diff <collection> <id> <from> [<to>]    print the net changes of a document between two points
+//	export [-from] [-to] [-format] [-out]   export audit logs as csv, jsonl, cef or leef
+//	verify                                  check the audit trail against the tracked document states
+//	scan [pattern]                          list the structs that would be registered for auditing
+//	rename <collection> <old>=<new>...      rename fields in the audit trail of a collection
+//
+// Points and dates are RFC 3339 dates or audit log ids. The connection and the audit collections
+// are read from the config file, see gaudit.ReadConfig.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/its-own/gaudit"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// errUsage reports a wrong invocation, the usage is printed with it
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand of the CLI
+type command struct {
+	usage string
+	run   func(ctx context.Context, env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"history": {"history <collection> <id>", runHistory},
+	"diff":    {"diff <collection> <id> <from> [<to>]", runDiff},
+	"export":  {"export [-collection name]... [-operation name]... [-from date] [-to date] [-format csv|jsonl|cef|leef] [-out file]", runExport},
+	"verify":  {"verify", runVerify},
+	"scan":    {"scan [pattern]", runScan},
+	"rename":  {"rename <collection> <old>=<new>...", runRename},
+}
+
+// env holds what the commands share: the audit collections and the output
+type env struct {
+	configPath string
+	out        io.Writer
+	logs       *mongo.Collection
+	metas      *mongo.Collection
+	client     *mongo.Client
+}
+
+// connect opens the audit collections described by the config file
+func (e *env) connect(ctx context.Context) error {
+	f, err := gaudit.ReadConfig(e.configPath)
+	if err != nil {
+		return err
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(f.Mongo.URI))
+	if err != nil {
+		return err
+	}
+	database := f.Storage.Database
+	if database == "" {
+		database = f.Mongo.Database
+	}
+	logs, metas := f.Storage.LogCollection, f.Storage.MetaCollection
+	if logs == "" {
+		logs = "audit_logs"
+	}
+	if metas == "" {
+		metas = "audit_logs_meta"
+	}
+	e.client = client
+	e.logs = client.Database(database).Collection(logs)
+	e.metas = client.Database(database).Collection(metas)
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gaudit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", envOr("GAUDIT_CONFIG", "config.yaml"), "config file, $GAUDIT_CONFIG")
+	fs.Usage = func() { usage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "gaudit: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	e := &env{configPath: *configPath, out: stdout}
+	defer func() {
+		if e.client != nil {
+			_ = e.client.Disconnect(context.Background())
+		}
+	}()
+	err := cmd.run(ctx, e, fs.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "gaudit: %v\nusage: gaudit %s\n", err, cmd.usage)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "gaudit: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: gaudit [-config file] <command> [flags] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range []string{"history", "diff", "export", "verify", "scan", "rename"} {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	fs.PrintDefaults()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func auditLog(at time.Time, changes map[string]in.AuditChange) in.AuditLog {
+	return in.AuditLog{Id: primitive.NewObjectID(), Operation: "update", AuditCreatedAt: &at, Change: changes}
+}
+
+func TestRun_Usage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"No command", nil, "usage: gaudit"},
+		{"Unknown command", []string{"purge"}, `unknown command "purge"`},
+		{"Missing arguments", []string{"history", "user"}, "usage: gaudit history <collection> <id>"},
+		{"Unknown format", []string{"export", "-format", "xml"}, `unknown format: "xml"`},
+		{"Rename without fields", []string{"rename", "user"}, "usage: gaudit rename <collection> <old>=<new>..."},
+		{"Rename with a bad pair", []string{"rename", "user", "first_name"}, `"first_name" is not an old=new field pair`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, 2, run(context.Background(), tt.args, &stdout, &stderr))
+			assert.Contains(t, stderr.String(), tt.want)
+		})
+	}
+}
+
+func TestParseRenames(t *testing.T) {
+	renames, err := parseRenames([]string{"first_name=firstname", "user_id=userid"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"first_name": "firstname", "user_id": "userid"}, renames)
+	assert.Equal(t, bson.M{"change.first_name": "change.firstname", "change.user_id": "change.userid"}, prefixed("change.", renames))
+
+	_, err = parseRenames([]string{"name=name"})
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	logs := []in.AuditLog{
+		auditLog(start, map[string]in.AuditChange{"name": {Old: "<nil>", New: "John"}, "age": {Old: "<nil>", New: "30"}}),
+		auditLog(start.Add(time.Hour), map[string]in.AuditChange{"name": {Old: "John", New: "Jane"}}),
+		auditLog(start.Add(2*time.Hour), map[string]in.AuditChange{"name": {Old: "Jane", New: "John"}, "age": {Old: "30", New: "31"}}),
+	}
+
+	from, err := parsePoint(logs[0].Id.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]in.AuditChange{"age": {Old: "30", New: "31"}},
+		netChanges(between(logs, from, point{at: time.Now()})), "name is back to its value")
+
+	from, err = parsePoint("2024-01-01T00:30:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]in.AuditChange{"name": {Old: "John", New: "Jane"}},
+		netChanges(between(logs, from, point{logId: logs[1].Id})))
+
+	_, err = parsePoint("yesterday")
+	assert.Error(t, err)
+}
+
+func TestPrintHistory(t *testing.T) {
+	var out bytes.Buffer
+	log := auditLog(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]in.AuditChange{"name": {Old: "John", New: "Jane"}})
+	log.UserID, log.UserType = "42", "admin"
+	printHistory(&out, []in.AuditLog{log})
+	assert.Equal(t, "2024-01-01T00:00:00Z  update  user=42 (admin)  log="+log.Id.Hex()+"\n    name: \"John\" -> \"Jane\"\n", out.String())
+}
+
+func TestVerifyMeta(t *testing.T) {
+	at := time.Date(2024, 1, 1, 10, 0, 0, 123456789, time.UTC)
+	logs := []in.AuditLog{
+		auditLog(at, map[string]in.AuditChange{"name": {Old: "<nil>", New: "John"}, "born": {Old: "<nil>", New: at.String() + " m=+0.000001"}}),
+		auditLog(at, map[string]in.AuditChange{"age": {Old: "<nil>", New: "30"}, "tags": {Old: "<nil>", New: "[a]"}}),
+	}
+	state := map[string]interface{}{
+		"_id":  "1",
+		"name": "John",
+		"age":  int32(30),
+		"born": primitive.NewDateTimeFromTime(at),
+		"tags": primitive.A{"a"},
+	}
+	assert.Empty(t, verifyMeta(meta{State: state}, logs))
+
+	state["name"] = "Jane"
+	assert.Equal(t, []string{`document 1: name is "John" in the audit logs but "Jane" in the tracked state`},
+		verifyMeta(meta{State: state}, logs))
+	assert.Equal(t, []string{"document 1: tracked without audit logs"}, verifyMeta(meta{State: state}, nil))
+}
+
+func TestRunScan(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, runScan(context.Background(), &env{out: &out}, []string{"../../example"}))
+	assert.Equal(t, "github.com/its-own/gaudit/exampleUser\n", out.String())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// runRename renames fields in the audit trail of a collection: the changes of its audit logs
+// and the tracked states of its documents. It migrates the trail of models whose fields are
+// recorded under another name, e.g. the untagged fields recorded under their snake_case name
+// before gaudit recorded them under the name the driver stores them with.
+func runRename(ctx context.Context, e *env, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%w: rename takes a collection and at least one old=new field", errUsage)
+	}
+	col := args[0]
+	renames, err := parseRenames(args[1:])
+	if err != nil {
+		return err
+	}
+	if err := e.connect(ctx); err != nil {
+		return err
+	}
+
+	logs, err := e.logs.UpdateMany(ctx, bson.M{"collection": col}, bson.M{"$rename": prefixed("change.", renames)})
+	if err != nil {
+		return err
+	}
+	// Metas don't record their collection, they are found through the audit logs
+	metaIds, err := e.logs.Distinct(ctx, "audit_meta_id", bson.M{"collection": col})
+	if err != nil {
+		return err
+	}
+	ids := make(bson.A, 0, len(metaIds))
+	for _, metaId := range metaIds {
+		hex, _ := metaId.(string)
+		if id, err := primitive.ObjectIDFromHex(hex); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	var metas int64
+	if len(ids) > 0 {
+		res, err := e.metas.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"$rename": prefixed("document_current_state.", renames)})
+		if err != nil {
+			return err
+		}
+		metas = res.ModifiedCount
+	}
+	fmt.Fprintf(e.out, "renamed fields in %d audit logs and %d tracked states of %s\n", logs.ModifiedCount, metas, col)
+	return nil
+}
+
+// parseRenames parses old=new field pairs
+func parseRenames(args []string) (map[string]string, error) {
+	renames := make(map[string]string, len(args))
+	for _, arg := range args {
+		old, new, ok := strings.Cut(arg, "=")
+		if !ok || old == "" || new == "" || old == new {
+			return nil, fmt.Errorf("%w: %q is not an old=new field pair", errUsage, arg)
+		}
+		renames[old] = new
+	}
+	return renames, nil
+}
+
+// prefixed returns the $rename spec of renames for fields under prefix
+func prefixed(prefix string, renames map[string]string) bson.M {
+	spec := make(bson.M, len(renames))
+	for old, new := range renames {
+		spec[prefix+old] = prefix + new
+	}
+	return spec
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+)
+
+func runScan(_ context.Context, e *env, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w: scan takes at most one pattern", errUsage)
+	}
+	pattern := "./..."
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	models, err := audit.Scan(pattern)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		fmt.Fprintln(e.out, model)
+	}
+	return nil
+}
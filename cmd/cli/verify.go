@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// meta is the last known state of an audited document
+type meta struct {
+	Id    primitive.ObjectID     `bson:"_id"`
+	State map[string]interface{} `bson:"document_current_state"`
+}
+
+// runVerify checks that the audit trail is complete: replaying the changes of every tracked
+// document must give its tracked state, and every audit log must identify what it audits
+func runVerify(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: verify takes no argument", errUsage)
+	}
+	if err := e.connect(ctx); err != nil {
+		return err
+	}
+
+	var problems []string
+	incomplete, err := e.logs.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"collection": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"document_id": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"operation": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"audit_created_at": nil},
+	}})
+	if err != nil {
+		return err
+	}
+	if incomplete > 0 {
+		problems = append(problems, fmt.Sprintf("%d audit logs miss their collection, document id, operation or date", incomplete))
+	}
+
+	cursor, err := e.metas.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var documents, logCount int
+	for cursor.Next(ctx) {
+		var m meta
+		if err := cursor.Decode(&m); err != nil {
+			return err
+		}
+		logs, err := metaLogs(ctx, e, m.Id)
+		if err != nil {
+			return err
+		}
+		documents++
+		logCount += len(logs)
+		problems = append(problems, verifyMeta(m, logs)...)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(e.out, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Fprintf(e.out, "ok: %d documents and %d audit logs verified\n", documents, logCount)
+	return nil
+}
+
+func metaLogs(ctx context.Context, e *env, id primitive.ObjectID) ([]in.AuditLog, error) {
+	cursor, err := e.logs.Find(ctx, bson.M{"audit_meta_id": id.Hex()},
+		options.Find().SetSort(bson.D{{Key: "audit_created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var logs []in.AuditLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// verifyMeta replays the changes of logs and reports the fields whose replayed value differs
+// from the tracked state. Fields that never changed are left out, since an untracked document
+// starts from a state that isn't logged.
+func verifyMeta(m meta, logs []in.AuditLog) []string {
+	docId := fmt.Sprintf("%v", m.State["_id"])
+	if len(logs) == 0 {
+		return []string{fmt.Sprintf("document %s: tracked without audit logs", docId)}
+	}
+	replayed := make(map[string]string)
+	for _, log := range logs {
+		for field, change := range log.Change {
+			replayed[field] = change.New
+		}
+	}
+
+	var problems []string
+	fields := make([]string, 0, len(replayed))
+	for field := range replayed {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value, ok := m.State[field]
+		if ok && !isScalar(value) {
+			continue
+		}
+		tracked := ""
+		if ok {
+			tracked = normalize(value)
+		}
+		if got := normalize(replayed[field]); got != tracked {
+			problems = append(problems, fmt.Sprintf("document %s: %s is %q in the audit logs but %q in the tracked state",
+				docId, field, got, tracked))
+		}
+	}
+	return problems
+}
+
+// isScalar reports whether value is compared by verify, nested documents and arrays aren't
+// since their stored representation differs from the one logged
+func isScalar(value interface{}) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		return false
+	}
+	return true
+}
+
+// loggedTime is the layout of a date logged with %v
+const loggedTime = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// normalize returns the comparable representation of a logged or stored value, dates are
+// truncated to the millisecond precision of MongoDB
+func normalize(value interface{}) string {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case string:
+		// Drop the monotonic clock reading of dates logged with %v
+		s, _, _ := strings.Cut(v, " m=")
+		if at, err := time.Parse(loggedTime, s); err == nil {
+			return at.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+		}
+		if v == "<nil>" {
+			return ""
+		}
+		return v
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,416 @@
+package gaudit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/webhook"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables overriding the settings of a config file, e.g.
+// GAUDIT_MONGO_URI overrides mongo.uri and GAUDIT_ASYNC_WORKERS overrides async.workers. Lists
+// are comma separated and maps are semicolon separated key=value pairs, e.g.
+// GAUDIT_REDACT="user=password,ssn;*=token". The webhooks of sinks.webhooks, a list of
+// sections, can only be set in the file.
+const EnvPrefix = "GAUDIT_"
+
+// FileConfig is the file representation of Config, see LoadConfig. Settings that aren't data,
+// e.g. AsyncConfig.OnDrop, can't be set from a file, set them on the built Config.
+type FileConfig struct {
+	Mongo struct {
+		URI      string `yaml:"uri"`
+		Database string `yaml:"database"`
+	} `yaml:"mongo"`
+	Log struct {
+		// Level is debug, info (default), warn or error
+		Level string `yaml:"level"`
+		// Format is text (default) or json
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+	Storage struct {
+		Database       string `yaml:"database"`
+		LogCollection  string `yaml:"log_collection"`
+		MetaCollection string `yaml:"meta_collection"`
+	} `yaml:"storage"`
+	Transactional bool `yaml:"transactional"`
+	Async         *struct {
+		Workers       int           `yaml:"workers"`
+		QueueSize     int           `yaml:"queue_size"`
+		BatchSize     int           `yaml:"batch_size"`
+		FlushInterval time.Duration `yaml:"flush_interval"`
+		// Backpressure is block (default) or drop
+		Backpressure string `yaml:"backpressure"`
+	} `yaml:"async"`
+	AuditFailure struct {
+		// Mode is log (default), surface or retry
+		Mode    string        `yaml:"mode"`
+		Retries int           `yaml:"retries"`
+		Backoff time.Duration `yaml:"backoff"`
+	} `yaml:"audit_failure"`
+	Retention *struct {
+		Policies  map[string]time.Duration `yaml:"policies"`
+		Default   time.Duration            `yaml:"default"`
+		Interval  time.Duration            `yaml:"interval"`
+		LegalHold struct {
+			Documents []string `yaml:"documents"`
+			Users     []string `yaml:"users"`
+		} `yaml:"legal_hold"`
+	} `yaml:"retention"`
+	ChangeStream *struct {
+		Collections     []string      `yaml:"collections"`
+		TokenCollection string        `yaml:"token_collection"`
+		RetryInterval   time.Duration `yaml:"retry_interval"`
+		Delay           time.Duration `yaml:"delay"`
+	} `yaml:"change_stream"`
+	// Redact lists the fields masked in the audit trail by collection, "*" for every collection
+	Redact map[string][]string `yaml:"redact"`
+	Sinks  struct {
+		Webhooks []struct {
+			URL           string        `yaml:"url"`
+			Secret        string        `yaml:"secret"`
+			Collections   []string      `yaml:"collections"`
+			Operations    []string      `yaml:"operations"`
+			BatchSize     int           `yaml:"batch_size"`
+			FlushInterval time.Duration `yaml:"flush_interval"`
+			MaxRetries    int           `yaml:"max_retries"`
+			QueueSize     int           `yaml:"queue_size"`
+		} `yaml:"webhooks"`
+	} `yaml:"sinks"`
+	// SoftDelete lists the collections whose deletes only set the deleted_at field of the docs
+	SoftDelete []string `yaml:"soft_delete"`
+}
+
+// LoadConfig reads the config file at path, applies the environment overrides, validates the
+// result and builds the Config it describes. The file is optional when path is empty, so the
+// environment alone can configure gaudit.
+func LoadConfig(ctx context.Context, path string) (*Config, error) {
+	f, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Build(ctx)
+}
+
+// ReadConfig reads and validates the config file at path with its environment overrides.
+// ${VAR} references in the file are expanded from the environment.
+func ReadConfig(path string) (*FileConfig, error) {
+	f := &FileConfig{}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		dec := yaml.NewDecoder(strings.NewReader(expandEnv(string(content))))
+		dec.KnownFields(true)
+		if err := dec.Decode(f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(f).Elem(), EnvPrefix); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Validate reports every invalid setting of f
+func (f *FileConfig) Validate() error {
+	var errs []error
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("config: %s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(field, value string, values ...string) {
+		if value == "" {
+			return
+		}
+		for _, v := range values {
+			if value == v {
+				return
+			}
+		}
+		invalid(field, "must be one of %s, got %q", strings.Join(values, ", "), value)
+	}
+	notNegative := func(field string, value int64) {
+		if value < 0 {
+			invalid(field, "must not be negative, got %d", value)
+		}
+	}
+
+	if f.Mongo.URI == "" {
+		invalid("mongo.uri", "is required")
+	} else if !strings.HasPrefix(f.Mongo.URI, "mongodb://") && !strings.HasPrefix(f.Mongo.URI, "mongodb+srv://") {
+		invalid("mongo.uri", "must start with mongodb:// or mongodb+srv://")
+	}
+	if f.Mongo.Database == "" {
+		invalid("mongo.database", "is required")
+	}
+	oneOf("log.level", f.Log.Level, "debug", "info", "warn", "error")
+	oneOf("log.format", f.Log.Format, "text", "json")
+	if f.Async != nil {
+		notNegative("async.workers", int64(f.Async.Workers))
+		notNegative("async.queue_size", int64(f.Async.QueueSize))
+		notNegative("async.batch_size", int64(f.Async.BatchSize))
+		notNegative("async.flush_interval", int64(f.Async.FlushInterval))
+		oneOf("async.backpressure", f.Async.Backpressure, "block", "drop")
+	}
+	oneOf("audit_failure.mode", f.AuditFailure.Mode, "log", "surface", "retry")
+	notNegative("audit_failure.retries", int64(f.AuditFailure.Retries))
+	notNegative("audit_failure.backoff", int64(f.AuditFailure.Backoff))
+	if f.Retention != nil {
+		cols := make([]string, 0, len(f.Retention.Policies))
+		for col := range f.Retention.Policies {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			notNegative("retention.policies."+col, int64(f.Retention.Policies[col]))
+		}
+		notNegative("retention.default", int64(f.Retention.Default))
+		notNegative("retention.interval", int64(f.Retention.Interval))
+	}
+	if f.ChangeStream != nil && len(f.ChangeStream.Collections) == 0 {
+		invalid("change_stream.collections", "is required")
+	}
+	for i, hook := range f.Sinks.Webhooks {
+		field := fmt.Sprintf("sinks.webhooks[%d]", i)
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid(field+".url", "must be an http(s) URL, got %q", hook.URL)
+		}
+		notNegative(field+".batch_size", int64(hook.BatchSize))
+		notNegative(field+".flush_interval", int64(hook.FlushInterval))
+		notNegative(field+".queue_size", int64(hook.QueueSize))
+	}
+	return errors.Join(errs...)
+}
+
+// Build connects to MongoDB and returns the Config described by f, to be passed to Init
+func (f *FileConfig) Build(ctx context.Context) (*Config, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(f.Mongo.URI))
+	if err != nil {
+		return nil, fmt.Errorf("config: mongo.uri: %w", err)
+	}
+	c := &Config{
+		Client:        client,
+		Database:      client.Database(f.Mongo.Database),
+		Logger:        f.logger(),
+		Transactional: f.Transactional,
+		Redact:        f.Redact,
+		SoftDelete:    f.SoftDelete,
+		Storage: StorageConfig{
+			LogCollection:  f.Storage.LogCollection,
+			MetaCollection: f.Storage.MetaCollection,
+		},
+		AuditFailure: FailurePolicy{
+			Mode:    map[string]FailureMode{"": LogFailure, "log": LogFailure, "surface": SurfaceFailure, "retry": RetryFailure}[f.AuditFailure.Mode],
+			Retries: f.AuditFailure.Retries,
+			Backoff: f.AuditFailure.Backoff,
+		},
+	}
+	if f.Storage.Database != "" {
+		c.Storage.Database = client.Database(f.Storage.Database)
+	}
+	if f.Async != nil {
+		c.Async = &AsyncConfig{
+			Workers:       f.Async.Workers,
+			QueueSize:     f.Async.QueueSize,
+			BatchSize:     f.Async.BatchSize,
+			FlushInterval: f.Async.FlushInterval,
+		}
+		if f.Async.Backpressure == "drop" {
+			c.Async.Backpressure = Drop
+		}
+	}
+	if f.Retention != nil {
+		c.Retention = &RetentionConfig{
+			Policies:  f.Retention.Policies,
+			Default:   f.Retention.Default,
+			Interval:  f.Retention.Interval,
+			LegalHold: LegalHold{Documents: f.Retention.LegalHold.Documents, Users: f.Retention.LegalHold.Users},
+		}
+	}
+	if f.ChangeStream != nil {
+		c.ChangeStream = &ChangeStreamConfig{
+			Collections:     f.ChangeStream.Collections,
+			TokenCollection: f.ChangeStream.TokenCollection,
+			RetryInterval:   f.ChangeStream.RetryInterval,
+			Delay:           f.ChangeStream.Delay,
+		}
+	}
+	var dispatchers []*webhook.Dispatcher
+	for _, hook := range f.Sinks.Webhooks {
+		dispatcher, err := webhook.New(webhook.Config{
+			URL:           hook.URL,
+			Secret:        hook.Secret,
+			Collections:   hook.Collections,
+			Operations:    hook.Operations,
+			BatchSize:     hook.BatchSize,
+			FlushInterval: hook.FlushInterval,
+			MaxRetries:    hook.MaxRetries,
+			QueueSize:     hook.QueueSize,
+			Logger:        c.Logger,
+		})
+		if err != nil {
+			// Nothing is returned to close what was started
+			for _, d := range dispatchers {
+				_ = d.Close(ctx)
+			}
+			_ = client.Disconnect(ctx)
+			return nil, fmt.Errorf("config: sinks.webhooks: %w", err)
+		}
+		dispatchers = append(dispatchers, dispatcher)
+		c.Sinks = append(c.Sinks, in.Sink(dispatcher))
+	}
+	return c, nil
+}
+
+// envRef matches the ${VAR} references of a config file
+var envRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// expandEnv replaces the ${VAR} references of s with the environment, a bare $ is kept so
+// secrets and URIs containing one are read as written
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+	})
+}
+
+func (f *FileConfig) logger() *slog.Logger {
+	level := map[string]slog.Level{"debug": slog.LevelDebug, "warn": slog.LevelWarn, "error": slog.LevelError}[f.Log.Level]
+	opts := &slog.HandlerOptions{Level: level}
+	if f.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// applyEnv overrides the fields of v, and of its nested structs, with the environment variables
+// named after their yaml path
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := applyEnv(field, key+"_"); err != nil {
+				return err
+			}
+			continue
+		case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+			// Optional sections are enabled by any of their variables
+			section := reflect.New(field.Type().Elem())
+			if field.IsNil() && !hasEnvPrefix(key+"_") {
+				continue
+			}
+			if !field.IsNil() {
+				section = field
+			}
+			if err := applyEnv(section.Elem(), key+"_"); err != nil {
+				return err
+			}
+			field.Set(section)
+			continue
+		}
+
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setScalar(field, value); err != nil {
+			return fmt.Errorf("config: %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setScalar parses value into field, lists of structs can't be set from the environment
+func setScalar(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("can't be set from the environment")
+		}
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String {
+			return errors.New("can't be set from the environment")
+		}
+		entries := reflect.MakeMap(field.Type())
+		for _, entry := range strings.Split(value, ";") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			key, v, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("%q is not a key=value pair", entry)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setScalar(elem, strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("%s: %w", strings.TrimSpace(key), err)
+			}
+			entries.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), elem)
+		}
+		field.Set(entries)
+	default:
+		return errors.New("can't be set from the environment")
+	}
+	return nil
+}
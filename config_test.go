@@ -0,0 +1,123 @@
+package gaudit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	t.Setenv("WEBHOOK_SECRET", "s3cr3t")
+	t.Setenv("GAUDIT_MONGO_DATABASE", "prod")
+	t.Setenv("GAUDIT_ASYNC_WORKERS", "8")
+	t.Setenv("GAUDIT_CHANGE_STREAM_COLLECTIONS", "user, order")
+	t.Setenv("GAUDIT_REDACT", "user=password, ssn; *=token")
+	path := writeConfig(t, `
+mongo:
+  uri: mongodb://localhost:27017
+  database: test
+async:
+  flush_interval: 1s
+  backpressure: drop
+retention:
+  policies:
+    session: 2160h
+redact:
+  user: [password]
+soft_delete: [user]
+sinks:
+  webhooks:
+    - url: https://example.com/audit
+      secret: ${WEBHOOK_SECRET}
+      queue_size: 50
+    - url: https://example.com/audit?token=$TOKEN
+      secret: pa$$word
+`)
+
+	f, err := ReadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", f.Mongo.Database, "the environment overrides the file")
+	assert.Equal(t, 8, f.Async.Workers)
+	assert.Equal(t, time.Second, f.Async.FlushInterval)
+	assert.Equal(t, []string{"user", "order"}, f.ChangeStream.Collections, "a variable enables its section")
+	assert.Equal(t, 2160*time.Hour, f.Retention.Policies["session"])
+	assert.Equal(t, map[string][]string{"user": {"password", "ssn"}, "*": {"token"}}, f.Redact, "a variable replaces a map")
+	assert.Equal(t, "s3cr3t", f.Sinks.Webhooks[0].Secret)
+	assert.Equal(t, 50, f.Sinks.Webhooks[0].QueueSize)
+	assert.Equal(t, "https://example.com/audit?token=$TOKEN", f.Sinks.Webhooks[1].URL, "only ${VAR} is expanded")
+	assert.Equal(t, "pa$$word", f.Sinks.Webhooks[1].Secret)
+
+	c, err := f.Build(context.Background())
+	require.NoError(t, err)
+	defer c.Client.Disconnect(context.Background())
+	assert.Equal(t, "prod", c.Database.Name())
+	assert.Equal(t, Drop, c.Async.Backpressure)
+	assert.Equal(t, []string{"password", "ssn"}, c.Redact["user"])
+	assert.Equal(t, []string{"user"}, c.SoftDelete)
+	assert.Len(t, c.Sinks, 2)
+}
+
+func TestReadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"Required settings", ``, []string{"mongo.uri: is required", "mongo.database: is required"}},
+		{"Unknown setting", "mongo:\n  url: mongodb://localhost\n", []string{"field url not found"}},
+		{
+			"Invalid settings",
+			`
+mongo: {uri: "localhost:27017", database: test}
+log: {level: verbose}
+async: {workers: -1, backpressure: wait}
+audit_failure: {mode: ignore}
+sinks: {webhooks: [{url: "example.com", queue_size: -1}]}
+`,
+			[]string{
+				`mongo.uri: must start with mongodb:// or mongodb+srv://`,
+				`log.level: must be one of debug, info, warn, error, got "verbose"`,
+				`async.workers: must not be negative, got -1`,
+				`async.backpressure: must be one of block, drop, got "wait"`,
+				`audit_failure.mode: must be one of log, surface, retry, got "ignore"`,
+				`sinks.webhooks[0].url: must be an http(s) URL, got "example.com"`,
+				`sinks.webhooks[0].queue_size: must not be negative, got -1`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadConfig(writeConfig(t, tt.content))
+			require.Error(t, err)
+			for _, want := range tt.want {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+
+	t.Run("Invalid variable", func(t *testing.T) {
+		t.Setenv("GAUDIT_ASYNC_WORKERS", "many")
+		_, err := ReadConfig(writeConfig(t, "mongo: {uri: mongodb://localhost, database: test}"))
+		assert.ErrorContains(t, err, "GAUDIT_ASYNC_WORKERS")
+	})
+
+	t.Run("Invalid map variable", func(t *testing.T) {
+		t.Setenv("GAUDIT_RETENTION_POLICIES", "session=2160h;ledger=forever")
+		_, err := ReadConfig(writeConfig(t, "mongo: {uri: mongodb://localhost, database: test}"))
+		assert.ErrorContains(t, err, "GAUDIT_RETENTION_POLICIES: ledger")
+
+		t.Setenv("GAUDIT_RETENTION_POLICIES", "session")
+		_, err = ReadConfig(writeConfig(t, "mongo: {uri: mongodb://localhost, database: test}"))
+		assert.ErrorContains(t, err, `"session" is not a key=value pair`)
+	})
+}
@@ -1,6 +1,9 @@
 package db
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // List of errors
 var (
@@ -8,4 +11,62 @@ var (
 	ErrNotFound        = errors.New("document: not found")
 	ErrDuplicateKey    = errors.New("infra: duplicate key")
 	ErrInvalidData     = errors.New("infra: invalid data")
+	ErrAborted         = errors.New("hook: write aborted")
+	ErrAuditFailed     = errors.New("hook: audit failed")
+	ErrConflict        = errors.New("document: version conflict")
+	ErrTimeout         = errors.New("infra: timeout")
+	ErrWriteConcern    = errors.New("infra: write concern failed")
 )
+
+// ConflictError is returned by Update when the stored doc doesn't have the version of the
+// updated model, see in.VersionTag. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Collection string
+	Version    int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s isn't at version %d", ErrConflict, e.Collection, e.Version)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// OpError is the failure of a NoSql method, it matches the error of the list above describing
+// the failure with errors.Is, e.g. ErrDuplicateKey, and the database error it comes from
+type OpError struct {
+	// Collection is empty for the methods not bound to a collection, e.g. Ping
+	Collection string
+	Operation  string
+	Err        error
+}
+
+func (e *OpError) Error() string {
+	if e.Collection == "" {
+		return fmt.Sprintf("%s: %v", e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s on %s: %v", e.Operation, e.Collection, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// WrapOp attaches the collection and the operation that failed to err, kind is the error of
+// the list above describing the failure, nil when unknown. Errors already carrying them, i.e.
+// OpError and ConflictError, are returned as is. Hook failures, matching ErrAborted or
+// ErrAuditFailed, are wrapped like any other error and keep matching them.
+func WrapOp(err error, col, ops string, kind error) error {
+	var (
+		opErr       *OpError
+		conflictErr *ConflictError
+	)
+	switch {
+	case err == nil, errors.As(err, &opErr), errors.As(err, &conflictErr):
+		return err
+	case kind != nil && !errors.Is(err, kind):
+		err = fmt.Errorf("%w: %w", kind, err)
+	}
+	return &OpError{Collection: col, Operation: ops, Err: err}
+}
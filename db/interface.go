@@ -7,14 +7,21 @@ import (
 	"time"
 )
 
-// NoSql interface wraps the database
+// NoSql interface wraps the database, its methods fail with errors matching the errors of
+// error.go, database failures are an *OpError naming the collection and the operation
 type NoSql interface {
 	Ping(ctx context.Context) error
 	Disconnect(ctx context.Context) error
+	Flush(ctx context.Context) error
 	EnsureIndices(ctx context.Context, tab string, index []Index) error
 	DropIndices(ctx context.Context, tab string, index []Index) error
 	Insert(ctx context.Context, tab string, v interface{}) error
 	Update(ctx context.Context, col string, filter interface{}, data interface{}) error
+	// Upsert sets data on the first doc matching filter, or inserts a doc made of the equality
+	// fields of filter and data when none matches
+	Upsert(ctx context.Context, col string, filter interface{}, data interface{}) error
+	// Replace replaces the first doc matching filter by doc, or inserts doc when none matches
+	Replace(ctx context.Context, col string, filter interface{}, doc interface{}) error
 	InsertMany(ctx context.Context, tab string, v []interface{}) error
 	Count(ctx context.Context, col string, q interface{}) (int64, error)
 	List(ctx context.Context, tab string, filter interface{}, skip, limit int64, v interface{}, sort ...interface{}) error
@@ -25,7 +32,21 @@ type NoSql interface {
 	Aggregate(ctx context.Context, col string, q []interface{}, v interface{}) error
 	AggregateWithDiskUse(ctx context.Context, col string, q []interface{}, v interface{}) error
 	Distinct(ctx context.Context, col, field string, q interface{}, v interface{}) error
+	// DeleteMany deletes the docs matching filter, in a soft-delete collection it sets their
+	// deleted_at field instead
 	DeleteMany(ctx context.Context, col string, filter interface{}) error
+	// DeleteOne deletes the first doc matching filter, in a soft-delete collection it sets its
+	// deleted_at field instead
+	DeleteOne(ctx context.Context, col string, filter interface{}) error
+	// FindOneAndDelete deletes the first doc matching filter like DeleteOne and decodes it into v,
+	// it returns ErrNotFound when no doc matches. It is the delete running the delete hooks of
+	// the model, see in.BeforeDelete.
+	FindOneAndDelete(ctx context.Context, col string, filter interface{}, v interface{}) error
+	// Restore undoes the soft delete of the docs matching filter
+	Restore(ctx context.Context, col string, filter interface{}) error
+	// WithTransaction runs fn in a transaction, the audit logs of the writes made with the ctx
+	// passed to fn are committed with the transaction and discarded if it aborts
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // Index holds database index
@@ -0,0 +1,26 @@
+package db
+
+import "context"
+
+// DeletedAt is the field set on the docs deleted from a soft-delete collection
+const DeletedAt = "deleted_at"
+
+type withDeletedKey struct{}
+
+// WithDeleted returns ctx whose reads include the soft-deleted docs
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withDeletedKey{}, true)
+}
+
+// IncludesDeleted reports whether reads made with ctx include the soft-deleted docs
+func IncludesDeleted(ctx context.Context) bool {
+	return ctx.Value(withDeletedKey{}) != nil
+}
+
+// NotDeleted returns filter restricted to the docs that aren't soft-deleted
+func NotDeleted(filter interface{}) interface{} {
+	if filter == nil {
+		return map[string]interface{}{DeletedAt: nil}
+	}
+	return map[string]interface{}{"$and": []interface{}{filter, map[string]interface{}{DeletedAt: nil}}}
+}
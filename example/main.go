@@ -21,7 +21,7 @@ func main() {
 		Logger:   slog.Default(),
 	})
 	// create user and pass gaudit mongo instance
-	_, err := NewUserRepo("user", aMgo).Create(ctx, &User{
+	err := NewUserRepo("user", aMgo).Create(ctx, &User{
 		ID:   primitive.NewObjectID(),
 		Name: "Razibul Hasan Mithu",
 	})
@@ -0,0 +1,183 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// csvHeader are the columns of every CSV export, followed by the old and new value of each field
+var csvHeader = []string{"id", "created_at", "collection", "document_id", "operation", "user_id", "user_type", "ip_address", "user_agent", "tags"}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func newCSVEncoder(w io.Writer, fields []string) (*csvEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w), fields: fields}
+	header := append([]string(nil), csvHeader...)
+	for _, field := range fields {
+		header = append(header, field+".old", field+".new")
+	}
+	if err := enc.w.Write(header); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func (e *csvEncoder) Encode(log in.AuditLog) error {
+	row := []string{log.Id.Hex(), createdAt(log).Format(time.RFC3339Nano), log.Collection, log.DocumentId, log.Operation,
+		log.UserID, log.UserType, log.AuditIPAddress, log.AuditUserAgent, strings.Join(log.AuditTags, ",")}
+	for _, field := range e.fields {
+		change := log.Change[field]
+		row = append(row, change.Old, change.New)
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLEncoder(w io.Writer) *jsonlEncoder {
+	buf := bufio.NewWriter(w)
+	return &jsonlEncoder{w: buf, enc: json.NewEncoder(buf)}
+}
+
+func (e *jsonlEncoder) Encode(log in.AuditLog) error {
+	return e.enc.Encode(log)
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// cefEncoder writes ArcSight CEF:0 lines
+type cefEncoder struct {
+	w    *bufio.Writer
+	opts Options
+}
+
+func newCEFEncoder(w io.Writer, opts Options) *cefEncoder {
+	return &cefEncoder{w: bufio.NewWriter(w), opts: opts}
+}
+
+func (e *cefEncoder) Encode(log in.AuditLog) error {
+	header := []string{"CEF:0", cefHeader(e.opts.Vendor), cefHeader(e.opts.Product), cefHeader(e.opts.Version),
+		cefHeader(log.Collection + "." + log.Operation), cefHeader(log.Operation + " " + log.Collection),
+		fmt.Sprint(severity(log))}
+	extension := []string{
+		"rt=" + fmt.Sprint(createdAt(log).UnixMilli()),
+		"externalId=" + log.Id.Hex(),
+		"act=" + cefValue(log.Operation),
+		"suser=" + cefValue(log.UserID),
+		"src=" + cefValue(log.AuditIPAddress),
+		"requestClientApplication=" + cefValue(log.AuditUserAgent),
+		"cs1Label=collection", "cs1=" + cefValue(log.Collection),
+		"cs2Label=documentId", "cs2=" + cefValue(log.DocumentId),
+		"cs3Label=userType", "cs3=" + cefValue(log.UserType),
+		"msg=" + cefValue(summary(log.Change)),
+	}
+	_, err := fmt.Fprintf(e.w, "%s|%s\n", strings.Join(header, "|"), strings.Join(extension, " "))
+	return err
+}
+
+func (e *cefEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// cefHeader escapes a CEF header field
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+// cefValue escapes a CEF extension value
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// leefEncoder writes QRadar LEEF:1.0 lines, attributes are tab separated
+type leefEncoder struct {
+	w    *bufio.Writer
+	opts Options
+}
+
+func newLEEFEncoder(w io.Writer, opts Options) *leefEncoder {
+	return &leefEncoder{w: bufio.NewWriter(w), opts: opts}
+}
+
+func (e *leefEncoder) Encode(log in.AuditLog) error {
+	header := []string{"LEEF:1.0", leefHeader(e.opts.Vendor), leefHeader(e.opts.Product), leefHeader(e.opts.Version),
+		leefHeader(log.Collection + "." + log.Operation)}
+	attributes := []string{
+		"devTime=" + fmt.Sprint(createdAt(log).UnixMilli()),
+		"sev=" + fmt.Sprint(severity(log)),
+		"cat=" + leefValue(log.Collection),
+		"usrName=" + leefValue(log.UserID),
+		"role=" + leefValue(log.UserType),
+		"src=" + leefValue(log.AuditIPAddress),
+		"userAgent=" + leefValue(log.AuditUserAgent),
+		"resource=" + leefValue(log.DocumentId),
+		"action=" + leefValue(log.Operation),
+		"externalId=" + log.Id.Hex(),
+		"msg=" + leefValue(summary(log.Change)),
+	}
+	_, err := fmt.Fprintf(e.w, "%s|%s\n", strings.Join(header, "|"), strings.Join(attributes, "\t"))
+	return err
+}
+
+func (e *leefEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// leefHeader escapes a LEEF header field
+func leefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+// leefValue escapes a LEEF attribute value
+func leefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// severity of an audit log on the 0-10 scale of CEF and LEEF, deletes are the most severe
+func severity(log in.AuditLog) int {
+	if log.Operation == "delete" {
+		return 5
+	}
+	return 3
+}
+
+func createdAt(log in.AuditLog) time.Time {
+	if log.AuditCreatedAt == nil {
+		return time.Time{}
+	}
+	return log.AuditCreatedAt.UTC()
+}
+
+// summary renders changes on one line, sorted by field
+func summary(changes map[string]in.AuditChange) string {
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s: %s -> %s", field, changes[field].Old, changes[field].New))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,176 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"time"
+)
+
+// Format of an export
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+	// CEF is the ArcSight Common Event Format
+	CEF Format = "cef"
+	// LEEF is the QRadar Log Event Extended Format
+	LEEF Format = "leef"
+)
+
+// ErrFormat is returned for an unknown Format
+var ErrFormat = errors.New("export: unknown format")
+
+// Filter selects the exported audit logs, zero fields select everything
+type Filter struct {
+	Collections []string
+	Operations  []string
+	// From and To bound the creation date of the audit logs, From included and To excluded
+	From time.Time
+	To   time.Time
+}
+
+// Query returns the MongoDB filter of f
+func (f Filter) Query() bson.M {
+	query := bson.M{}
+	if len(f.Collections) > 0 {
+		query["collection"] = bson.M{"$in": f.Collections}
+	}
+	if len(f.Operations) > 0 {
+		query["operation"] = bson.M{"$in": f.Operations}
+	}
+	created := bson.M{}
+	if !f.From.IsZero() {
+		created["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		created["$lt"] = f.To
+	}
+	if len(created) > 0 {
+		query["audit_created_at"] = created
+	}
+	return query
+}
+
+// Options configures the encoding of an export
+type Options struct {
+	Format Format
+	// Fields are the changed fields given a column in CSV exports, every changed field of the
+	// exported audit logs when nil
+	Fields []string
+	// Vendor, Product and Version identify the device in CEF and LEEF headers, "its-own",
+	// "gaudit" and "1.0" by default
+	Vendor  string
+	Product string
+	Version string
+}
+
+// Encoder writes audit logs in one format, Flush must be called once every log is encoded
+type Encoder interface {
+	Encode(log in.AuditLog) error
+	Flush() error
+}
+
+// NewEncoder returns an Encoder writing to w in opts.Format
+func NewEncoder(w io.Writer, opts Options) (Encoder, error) {
+	if opts.Vendor == "" {
+		opts.Vendor = "its-own"
+	}
+	if opts.Product == "" {
+		opts.Product = "gaudit"
+	}
+	if opts.Version == "" {
+		opts.Version = "1.0"
+	}
+	switch opts.Format {
+	case CSV:
+		return newCSVEncoder(w, opts.Fields)
+	case JSONL:
+		return newJSONLEncoder(w), nil
+	case CEF:
+		return newCEFEncoder(w, opts), nil
+	case LEEF:
+		return newLEEFEncoder(w, opts), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrFormat, opts.Format)
+}
+
+// Exporter streams audit logs out of a collection, oldest first. Audit logs are read through a
+// cursor, an export never holds more than a cursor batch in memory.
+type Exporter struct {
+	logs *mongo.Collection
+}
+
+// New returns an Exporter of the audit logs of collection logs
+func New(logs *mongo.Collection) *Exporter {
+	return &Exporter{logs: logs}
+}
+
+// Export writes the audit logs selected by filter to w and returns their count
+func (e *Exporter) Export(ctx context.Context, w io.Writer, filter Filter, opts Options) (int64, error) {
+	if opts.Format == CSV && opts.Fields == nil {
+		fields, err := e.ChangedFields(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		opts.Fields = fields
+	}
+	enc, err := NewEncoder(w, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := e.logs.Find(ctx, filter.Query(),
+		options.Find().SetSort(bson.D{{Key: "audit_created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, fmt.Errorf("error finding audit logs: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var count int64
+	for cursor.Next(ctx) {
+		var log in.AuditLog
+		if err := cursor.Decode(&log); err != nil {
+			return count, fmt.Errorf("error decoding audit log: %w", err)
+		}
+		if err := enc.Encode(log); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	return count, enc.Flush()
+}
+
+// ChangedFields returns the sorted names of the fields changed by the audit logs selected by
+// filter, computed by the server
+func (e *Exporter) ChangedFields(ctx context.Context, filter Filter) ([]string, error) {
+	cursor, err := e.logs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter.Query()}},
+		{{Key: "$project", Value: bson.M{"change": bson.M{"$objectToArray": "$change"}}}},
+		{{Key: "$unwind", Value: "$change"}},
+		{{Key: "$group", Value: bson.M{"_id": "$change.k"}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding changed fields: %w", err)
+	}
+	var groups []struct {
+		Field string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("error finding changed fields: %w", err)
+	}
+	fields := make([]string, 0, len(groups))
+	for _, g := range groups {
+		fields = append(fields, g.Field)
+	}
+	return fields, nil
+}
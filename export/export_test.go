@@ -0,0 +1,91 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	at     = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	testId = primitive.NewObjectID()
+	// testLog has values that need escaping in every format
+	testLog = in.AuditLog{
+		Id:             testId,
+		Collection:     "user",
+		Operation:      "update",
+		DocumentId:     "42",
+		AuditIPAddress: "10.0.0.1",
+		AuditUserAgent: "curl|8.0",
+		AuditTags:      []string{"audit", "log"},
+		AuditCreatedAt: &at,
+		UserID:         "admin",
+		UserType:       "root",
+		Change: map[string]in.AuditChange{
+			"name": {Old: "John", New: "Jane=\tDoe"},
+			"age":  {Old: "30", New: "31"},
+		},
+	}
+)
+
+func encode(t *testing.T, opts Options, logs ...in.AuditLog) string {
+	var out bytes.Buffer
+	enc, err := NewEncoder(&out, opts)
+	require.NoError(t, err)
+	for _, log := range logs {
+		require.NoError(t, enc.Encode(log))
+	}
+	require.NoError(t, enc.Flush())
+	return out.String()
+}
+
+func TestFilter_Query(t *testing.T) {
+	to := at.Add(time.Hour)
+	assert.Equal(t, bson.M{}, Filter{}.Query())
+	assert.Equal(t, bson.M{
+		"collection":       bson.M{"$in": []string{"user"}},
+		"operation":        bson.M{"$in": []string{"delete"}},
+		"audit_created_at": bson.M{"$gte": at, "$lt": to},
+	}, Filter{Collections: []string{"user"}, Operations: []string{"delete"}, From: at, To: to}.Query())
+}
+
+func TestCSV(t *testing.T) {
+	got := encode(t, Options{Format: CSV, Fields: []string{"age", "email", "name"}}, testLog)
+	assert.Equal(t, "id,created_at,collection,document_id,operation,user_id,user_type,ip_address,user_agent,tags,"+
+		"age.old,age.new,email.old,email.new,name.old,name.new\n"+
+		testId.Hex()+",2024-01-02T03:04:05Z,user,42,update,admin,root,10.0.0.1,curl|8.0,\"audit,log\",30,31,,,John,Jane=\tDoe\n", got)
+}
+
+func TestJSONL(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(encode(t, Options{Format: JSONL}, testLog, testLog)), "\n")
+	require.Len(t, lines, 2)
+	var log in.AuditLog
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &log))
+	assert.Equal(t, testLog.Change, log.Change)
+}
+
+func TestCEF(t *testing.T) {
+	got := encode(t, Options{Format: CEF}, testLog)
+	assert.Equal(t, `CEF:0|its-own|gaudit|1.0|user.update|update user|3|rt=1704164645000 externalId=`+testId.Hex()+
+		` act=update suser=admin src=10.0.0.1 requestClientApplication=curl|8.0 cs1Label=collection cs1=user`+
+		` cs2Label=documentId cs2=42 cs3Label=userType cs3=root msg=age: 30 -> 31; name: John -> Jane\=`+"\tDoe\n", got)
+}
+
+func TestLEEF(t *testing.T) {
+	got := encode(t, Options{Format: LEEF, Vendor: "acme|corp"}, testLog)
+	assert.Equal(t, "LEEF:1.0|acme\\|corp|gaudit|1.0|user.update|devTime=1704164645000\tsev=3\tcat=user\tusrName=admin"+
+		"\trole=root\tsrc=10.0.0.1\tuserAgent=curl|8.0\tresource=42\taction=update\texternalId="+testId.Hex()+
+		"\tmsg=age: 30 -> 31; name: John -> Jane=\\tDoe\n", got)
+}
+
+func TestNewEncoder_UnknownFormat(t *testing.T) {
+	_, err := NewEncoder(&bytes.Buffer{}, Options{Format: "xml"})
+	assert.ErrorIs(t, err, ErrFormat)
+}
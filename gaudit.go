@@ -1,26 +1,201 @@
 package gaudit
 
 import (
+	"context"
+	"fmt"
 	"github.com/its-own/gaudit/db"
 	"github.com/its-own/gaudit/in"
-	_ "github.com/its-own/gaudit/internal/audit_log"
+	audit "github.com/its-own/gaudit/internal/audit_log"
 	"github.com/its-own/gaudit/internal/hooks"
+	"github.com/its-own/gaudit/internal/infracture/db/memory"
 	amgo "github.com/its-own/gaudit/internal/infracture/db/mongo"
 	"go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
+	"sync"
+	"time"
 )
 
 func init() {
 	slog.Default().Info("gaudit in action")
 }
 
+// AsyncConfig configures background audit writes, see Config.Async
+type AsyncConfig = hooks.AsyncOptions
+
+// Backpressure decides what happens to an audit log when the async queue is full
+type Backpressure = hooks.Backpressure
+
+const (
+	Block = hooks.Block
+	Drop  = hooks.Drop
+)
+
+// ChangeStreamConfig configures the auditing of writes made outside of gaudit, see Config.ChangeStream
+type ChangeStreamConfig = hooks.ChangeStreamOptions
+
+// FailurePolicy decides how PostSave failures outside a transaction are handled, see Config.AuditFailure
+type FailurePolicy = hooks.FailurePolicy
+
+// FailureMode is the mode of a FailurePolicy
+type FailureMode = hooks.FailureMode
+
+const (
+	LogFailure     = hooks.LogFailure
+	SurfaceFailure = hooks.SurfaceFailure
+	RetryFailure   = hooks.RetryFailure
+)
+
+// StorageConfig configures where the audit trail is stored and its indexes, see Config.Storage
+type StorageConfig = hooks.StorageOptions
+
+// DefaultLogIndexes and DefaultMetaIndexes are the indexes ensured by Init when StorageConfig sets none
+var (
+	DefaultLogIndexes  = hooks.DefaultLogIndexes
+	DefaultMetaIndexes = hooks.DefaultMetaIndexes
+)
+
+// RetentionConfig configures how long audit logs are kept, see Config.Retention
+type RetentionConfig = hooks.RetentionOptions
+
+// LegalHold lists the audit logs exempted from retention
+type LegalHold = hooks.LegalHold
+
+// ModelHooks are hooks running on writes of one model type
+type ModelHooks struct {
+	// Model is any value of the model type, e.g. &User{}
+	Model interface{}
+	Hooks []in.HookV2
+}
+
 type Config struct {
 	*mongo.Client
 	Database *mongo.Database
-	hook     in.Hook
 	Logger   *slog.Logger
+	// Hooks run on every write, use in.WrapHook for an in.Hook. Before a write, the global hooks
+	// run first, then CollectionHooks, then ModelHooks, then the audit hook; an error returned
+	// by a PreSave vetoes the write. After a write, the audit hook runs first, then the others
+	// in the same order.
+	Hooks []in.HookV2
+	// CollectionHooks run on writes to the collection they are keyed by
+	CollectionHooks map[string][]in.HookV2
+	ModelHooks      []ModelHooks
+	// AuditFailure decides whether a failing PostSave hook, the audit included, is logged (default),
+	// retried or returned to the caller wrapped with db.ErrAuditFailed. A vetoing PreSave error is
+	// always returned, wrapped with db.ErrAborted.
+	AuditFailure FailurePolicy
+	// Sinks receive every audit log once it is written, e.g. a webhook.Dispatcher
+	Sinks []in.Sink
+	// Async writes audit logs in batches from background workers instead of after every write.
+	// Pending audit logs are written by NoSql.Flush and NoSql.Disconnect.
+	Async *AsyncConfig
+	// Transactional commits every audited write atomically with its audit logs, so an audited
+	// change never exists without its audit record. Requires a replica set or sharded cluster,
+	// and audit logs of these writes are always written synchronously.
+	Transactional bool
+	// ChangeStream audits the writes to these collections that bypass gaudit (other services,
+	// migrations, the mongo shell) through change streams. Requires a replica set or sharded cluster.
+	ChangeStream *ChangeStreamConfig
+	// Storage sets the database and collections holding the audit trail, and their indexes.
+	// Init ensures the indexes, set empty index lists to manage them yourself.
+	Storage StorageConfig
+	// Retention purges the audit logs past the retention of their collection in the background,
+	// except the ones on legal hold. Audit logs are kept forever when nil.
+	Retention *RetentionConfig
+	// Redact masks fields in the audit trail, keyed by collection ("*" for every collection), e.g.
+	// {"user": {"password"}}. Changes of redacted fields aren't recorded.
+	Redact map[string][]string
+	// SoftDelete lists the collections whose deletes set the deleted_at field of the docs instead
+	// of removing them. FindOne, List and Count skip soft-deleted docs unless their ctx comes from
+	// db.WithDeleted, and NoSql.Restore undoes a soft delete. Soft deletes and restores of audited
+	// docs are audited with the "soft_delete" and "restore" audit events.
+	SoftDelete []string
 }
 
 func Init(c *Config) db.NoSql {
-	return amgo.InitMongo(c.Client, c.Database, hooks.NewDefaultHook(c.Logger))
+	registerModels(c)
+	var hook *hooks.DefaultHooks
+	if c.Async != nil {
+		hook = hooks.NewAsyncHook(c.Logger, c.Database, *c.Async, c.Sinks...)
+	} else {
+		hook = hooks.NewDefaultHook(c.Logger, c.Database, c.Sinks...)
+	}
+	hook.WithStorage(c.Storage).WithRedaction(c.Redact)
+	ensureIndices(c, hook.Storage())
+	conn := amgo.InitMongo(c.Client, c.Database, newChain(c, hook), amgo.Options{Transactional: c.Transactional, SoftDelete: c.SoftDelete})
+	if c.ChangeStream != nil {
+		hook.Watch(*c.ChangeStream)
+	}
+	if c.Retention != nil {
+		hook.Retain(*c.Retention)
+	}
+	return conn
+}
+
+// InitMemory returns an in-memory db.NoSql audited like Init, for unit tests: it runs the
+// hooks of c and keeps the audit trail in its "audit_logs" and "audit_logs_meta" collections.
+// Audit logs are written synchronously, the connection, Async, Transactional, ChangeStream,
+// Storage and Retention settings of c are ignored.
+func InitMemory(c *Config) db.NoSql {
+	registerModels(c)
+	conn := memory.InitMemory(nil).WithSoftDelete(c.SoftDelete...)
+	hook := hooks.NewDefaultHook(c.Logger, nil, c.Sinks...).
+		WithStore(memory.NewAuditStore(conn, "audit_logs", "audit_logs_meta")).
+		WithRedaction(c.Redact)
+	return conn.WithHook(newChain(c, hook))
+}
+
+// newChain runs the hooks of c around the audit hook
+func newChain(c *Config, hook *hooks.DefaultHooks) *hooks.Chain {
+	chain := hooks.NewChain(hook).Use(c.Hooks...).WithFailurePolicy(c.AuditFailure)
+	for col, colHooks := range c.CollectionHooks {
+		chain.UseForCollection(col, colHooks...)
+	}
+	for _, m := range c.ModelHooks {
+		chain.UseForModel(m.Model, m.Hooks...)
+	}
+	return chain
+}
+
+var scanOnce sync.Once
+
+// registerModels registers the models with in.Inject of the project once per process. A failure,
+// e.g. when running outside of a Go module, is logged: models are still registered by NewRepo
+// and audit.RegisterModel.
+func registerModels(c *Config) {
+	scanOnce.Do(func() {
+		if err := audit.WatchAndInjectHooks(context.Background()); err != nil {
+			l := c.Logger
+			if l == nil {
+				l = slog.Default()
+			}
+			l.Warn(fmt.Sprintf("Failed to scan the project for audited models: %v", err))
+		}
+	})
+}
+
+// ensureIndices creates the indexes of the audit collections, a failure is logged since
+// auditing works without them, only slower
+func ensureIndices(c *Config, storage StorageConfig) {
+	if storage.Database == nil {
+		return
+	}
+	l := c.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn := amgo.InitMongo(c.Client, storage.Database, nil, amgo.Options{})
+	for col, indices := range map[string][]db.Index{
+		storage.LogCollection:  storage.LogIndexes,
+		storage.MetaCollection: storage.MetaIndexes,
+	} {
+		if len(indices) == 0 {
+			continue
+		}
+		if err := conn.EnsureIndices(ctx, col, indices); err != nil {
+			l.Error(fmt.Sprintf("Failed to ensure indices of %s: %v", col, err))
+		}
+	}
 }
@@ -0,0 +1,79 @@
+// Package gauditest helps unit testing code audited by gaudit without a MongoDB server.
+//
+//	conn := gauditest.New(t, &gaudit.Config{})
+//	repo := NewUserRepo("user", conn)
+//	_ = repo.Rename(ctx, id, "Mithu")
+//	gauditest.AssertAudited(t, conn, "user", id.Hex(), map[string]in.AuditChange{
+//		"name": {Old: "Razibul", New: "Mithu"},
+//	})
+package gauditest
+
+import (
+	"context"
+	"github.com/its-own/gaudit"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+// LogCollection holds the audit logs of the databases returned by New
+const LogCollection = "audit_logs"
+
+// New returns an in-memory database audited with c, see gaudit.InitMemory. A nil c audits
+// with the default configuration. The database is disconnected when the test ends.
+func New(t testing.TB, c *gaudit.Config) db.NoSql {
+	t.Helper()
+	if c == nil {
+		c = &gaudit.Config{}
+	}
+	conn := gaudit.InitMemory(c)
+	t.Cleanup(func() {
+		if err := conn.Disconnect(context.Background()); err != nil {
+			t.Errorf("gauditest: disconnect: %v", err)
+		}
+	})
+	return conn
+}
+
+// AuditLogs returns the audit logs of document id of collection col, oldest first
+func AuditLogs(t testing.TB, d db.NoSql, col, id string) []in.AuditLog {
+	t.Helper()
+	var logs []in.AuditLog
+	filter := bson.M{"collection": col, "document_id": id}
+	sort := bson.D{{Key: "audit_created_at", Value: 1}, {Key: "_id", Value: 1}}
+	if err := d.List(context.Background(), LogCollection, filter, 0, 0, &logs, sort); err != nil {
+		t.Fatalf("gauditest: listing the audit logs of %s %s: %v", col, id, err)
+	}
+	return logs
+}
+
+// AssertAudited asserts that the last write to document id of collection col was audited and
+// recorded changes. Only the fields of changes are compared, the old value of an inserted field
+// is "<nil>". A nil changes only asserts that the document was audited.
+func AssertAudited(t testing.TB, d db.NoSql, col, id string, changes map[string]in.AuditChange) bool {
+	t.Helper()
+	logs := AuditLogs(t, d, col, id)
+	if len(logs) == 0 {
+		return assert.Fail(t, "document isn't audited", "no audit log of %s %s", col, id)
+	}
+	last := logs[len(logs)-1]
+	if changes == nil {
+		return true
+	}
+	got := make(map[string]in.AuditChange, len(changes))
+	for field := range changes {
+		if change, ok := last.Change[field]; ok {
+			got[field] = change
+		}
+	}
+	return assert.Equal(t, changes, got, "changes of the last %s of %s %s", last.Operation, col, id)
+}
+
+// AssertNotAudited asserts that no write to document id of collection col was audited
+func AssertNotAudited(t testing.TB, d db.NoSql, col, id string) bool {
+	t.Helper()
+	logs := AuditLogs(t, d, col, id)
+	return assert.Empty(t, logs, "audit logs of %s %s", col, id)
+}
@@ -0,0 +1,72 @@
+package gauditest
+
+import (
+	"context"
+	"errors"
+	"github.com/its-own/gaudit"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+type user struct {
+	in.Inject
+	ID   primitive.ObjectID `bson:"_id"`
+	Name string             `bson:"name"`
+	Age  int                `bson:"age"`
+	Seen time.Time          `bson:"seen,omitempty"`
+}
+
+// veto is an in.HookV2 rejecting every write
+type veto struct{}
+
+func (veto) PreSave(context.Context, interface{}, interface{}, string, string, string) error {
+	return errors.New("read only")
+}
+
+func (veto) PostSave(context.Context, interface{}, interface{}, string, string, string) error {
+	return nil
+}
+
+func TestAssertAudited(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/gauditestuser")
+	ctx := context.Background()
+	conn := New(t, nil)
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu", Age: 30}
+	id := u.ID.Hex()
+
+	AssertNotAudited(t, conn, "user", id)
+	require.NoError(t, conn.Insert(ctx, "user", u))
+	AssertAudited(t, conn, "user", id, map[string]in.AuditChange{
+		"name": {Old: "<nil>", New: "Mithu"},
+		"age":  {Old: "<nil>", New: "30"},
+	})
+
+	require.NoError(t, conn.Update(ctx, "user", bson.M{"_id": u.ID}, &user{ID: u.ID, Name: "Mithu", Age: 31}))
+	AssertAudited(t, conn, "user", id, map[string]in.AuditChange{"age": {Old: "30", New: "31"}})
+
+	require.NoError(t, conn.DeleteMany(ctx, "user", bson.M{"_id": u.ID}))
+	AssertAudited(t, conn, "user", id, map[string]in.AuditChange{"age": {Old: "31"}})
+	logs := AuditLogs(t, conn, "user", id)
+	require.Len(t, logs, 3)
+	assert.Equal(t, []string{"insert", "update", "delete"}, []string{logs[0].Operation, logs[1].Operation, logs[2].Operation})
+
+	mock := &testing.T{}
+	assert.False(t, AssertAudited(mock, conn, "user", id, map[string]in.AuditChange{"age": {Old: "30"}}))
+	assert.False(t, AssertAudited(mock, conn, "user", primitive.NewObjectID().Hex(), nil))
+	assert.True(t, mock.Failed())
+}
+
+func TestNew_RunsHooks(t *testing.T) {
+	conn := New(t, &gaudit.Config{Hooks: []in.HookV2{veto{}}})
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu"}
+	err := conn.Insert(context.Background(), "user", u)
+	assert.ErrorIs(t, err, db.ErrAborted)
+	AssertNotAudited(t, conn, "user", u.ID.Hex())
+}
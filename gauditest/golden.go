@@ -0,0 +1,83 @@
+package gauditest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// update rewrites the golden files instead of comparing them: go test ./... -gauditest.update
+var update = flag.Bool("gauditest.update", false, "rewrite the gauditest golden files")
+
+var (
+	objectIdPattern = regexp.MustCompile(`\b[0-9a-f]{24}\b`)
+	// timePattern matches RFC 3339 dates and dates formatted with %v, monotonic reading included
+	timePattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z| ?[+-]\d{2}:?\d{2})?( [A-Z]{3,5}| [+-]\d{2,4})?( m=[+-]\d+\.\d+)?`)
+)
+
+// Scrub renders logs as indented JSON without what changes from run to run: the ids, meta ids
+// and dates of the audit logs are dropped, dates in changes become "<time>" and ObjectIDs become
+// "<id1>", "<id2>"... in order of appearance, so the same id is scrubbed the same way.
+func Scrub(logs []in.AuditLog) (string, error) {
+	data, err := json.Marshal(logs)
+	if err != nil {
+		return "", err
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		for _, key := range []string{"id", "audit_meta_id", "audit_created_at", "audit_updated_at"} {
+			delete(entry, key)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		return "", err
+	}
+	out := timePattern.ReplaceAllString(buf.String(), "<time>")
+	ids := make(map[string]string)
+	out = objectIdPattern.ReplaceAllStringFunc(out, func(id string) string {
+		if _, ok := ids[id]; !ok {
+			ids[id] = fmt.Sprintf("<id%d>", len(ids)+1)
+		}
+		return ids[id]
+	})
+	return out, nil
+}
+
+// AssertGolden asserts that the scrubbed logs equal the golden file testdata/<name>.golden,
+// see Scrub. Run the tests with -gauditest.update to write the golden files.
+func AssertGolden(t testing.TB, name string, logs []in.AuditLog) bool {
+	t.Helper()
+	got, err := Scrub(logs)
+	if err != nil {
+		t.Fatalf("gauditest: scrubbing audit logs: %v", err)
+	}
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("gauditest: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("gauditest: %v", err)
+		}
+		return true
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("gauditest: %v, run the tests with -gauditest.update to create it", err)
+	}
+	return assert.Equal(t, string(want), got, "audit logs differ from %s", path)
+}
@@ -0,0 +1,51 @@
+package gauditest
+
+import (
+	"context"
+	"github.com/its-own/gaudit"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	"sync"
+	"testing"
+)
+
+// Recorder is an in.Sink keeping every audit log it receives, in order. Add it to
+// gaudit.Config.Sinks to capture the audit trail written during a test.
+type Recorder struct {
+	mu   sync.Mutex
+	logs []in.AuditLog
+}
+
+// Send records logs
+func (r *Recorder) Send(ctx context.Context, logs []in.AuditLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, logs...)
+	return nil
+}
+
+// Logs returns the recorded audit logs
+func (r *Recorder) Logs() []in.AuditLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]in.AuditLog(nil), r.logs...)
+}
+
+// Reset forgets the recorded audit logs, e.g. the ones of a test's fixtures
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = nil
+}
+
+// Record is New with a Recorder capturing the audit logs written to the returned database
+func Record(t testing.TB, c *gaudit.Config) (db.NoSql, *Recorder) {
+	t.Helper()
+	rec := &Recorder{}
+	config := gaudit.Config{}
+	if c != nil {
+		config = *c
+	}
+	config.Sinks = append(append([]in.Sink(nil), config.Sinks...), rec)
+	return New(t, &config), rec
+}
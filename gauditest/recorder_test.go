@@ -0,0 +1,63 @@
+package gauditest
+
+import (
+	"context"
+	"github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Golden(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/gauditestuser")
+	ctx := context.WithValue(context.Background(), "user_id", "admin")
+	conn, rec := Record(t, nil)
+
+	fixture := &user{ID: primitive.NewObjectID(), Name: "Fixture"}
+	require.NoError(t, conn.Insert(ctx, "user", fixture))
+	rec.Reset()
+
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu", Age: 30}
+	require.NoError(t, conn.Insert(ctx, "user", u))
+	require.NoError(t, conn.Update(ctx, "user", bson.M{"_id": u.ID}, &user{ID: u.ID, Name: "Mithu", Age: 31, Seen: time.Now()}))
+	require.NoError(t, conn.DeleteMany(ctx, "user", bson.M{"_id": u.ID}))
+
+	logs := rec.Logs()
+	require.Len(t, logs, 3)
+	AssertGolden(t, "user_lifecycle", logs)
+}
+
+func TestScrub(t *testing.T) {
+	id := primitive.NewObjectID().Hex()
+	now := time.Now()
+	got, err := Scrub([]in.AuditLog{{
+		Id:             primitive.NewObjectID(),
+		AuditMetaId:    primitive.NewObjectID().Hex(),
+		AuditCreatedAt: &now,
+		DocumentId:     id,
+		Change: map[string]in.AuditChange{
+			"owner": {New: id},
+			"seen":  {Old: now.String(), New: now.UTC().Format(time.RFC3339Nano)},
+		},
+	}})
+	require.NoError(t, err)
+	assert.NotContains(t, got, id)
+	assert.Equal(t, 2, countOf(got, `"<id1>"`), "the same id is scrubbed the same way")
+	assert.Equal(t, 2, countOf(got, `"<time>"`))
+	assert.NotContains(t, got, "audit_created_at")
+	assert.NotContains(t, got, "audit_meta_id")
+}
+
+func countOf(s, sub string) int {
+	n := 0
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if s[i:i+len(sub)] == sub {
+			n++
+		}
+	}
+	return n
+}
@@ -8,5 +8,33 @@ type Hook interface {
 	PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string)
 }
 
+// HookV2 is a Hook able to stop a write: an error returned by PreSave vetoes the write
+// and is returned to the caller. An error returned by PostSave is handled by the failure
+// policy, see gaudit.Config.AuditFailure: logged by default, surfaced or retried, and
+// always returned inside a transaction.
+type HookV2 interface {
+	PreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error
+	PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error
+}
+
+// WrapHook adapts a Hook to HookV2, the adapted hook never fails
+func WrapHook(h Hook) HookV2 {
+	return hookV1{h}
+}
+
+type hookV1 struct {
+	h Hook
+}
+
+func (w hookV1) PreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	w.h.PreSave(ctx, model, filter, col, ops, docId)
+	return nil
+}
+
+func (w hookV1) PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	w.h.PostSave(ctx, model, filter, col, ops, docId)
+	return nil
+}
+
 type Inject struct {
 }
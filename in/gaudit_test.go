@@ -1,7 +1,37 @@
 package in
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestDemo(t *testing.T) {
 
 }
+
+// countingHook is a Hook counting its calls
+type countingHook struct {
+	pre, post int
+}
+
+func (h *countingHook) PreSave(context.Context, interface{}, interface{}, string, string, string) {
+	h.pre++
+}
+
+func (h *countingHook) PostSave(context.Context, interface{}, interface{}, string, string, string) {
+	h.post++
+}
+
+func TestWrapHook(t *testing.T) {
+	h := &countingHook{}
+	v2 := WrapHook(h)
+	if err := v2.PreSave(context.Background(), nil, nil, "user", "insert", ""); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := v2.PostSave(context.Background(), nil, nil, "user", "insert", "1"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if h.pre != 1 || h.post != 1 {
+		t.Errorf("expected one call each, got PreSave %d and PostSave %d", h.pre, h.post)
+	}
+}
@@ -0,0 +1,80 @@
+package in
+
+import (
+	"encoding/hex"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strconv"
+)
+
+// DocumentID returns the canonical form of a document _id, the AuditLog.DocumentId of its
+// audit logs: the hex of an ObjectID, a string as is, the decimal of a number, the text form
+// of a UUID, the hex of other binary values and the relaxed extended JSON of any other value,
+// e.g. a compound _id, with the fields of embedded documents sorted.
+func DocumentID(id interface{}) string {
+	switch v := id.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	}
+	// Go types are first converted to the BSON types they are stored as
+	data, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	switch v := doc["_id"].(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case primitive.Binary:
+		if (v.Subtype == 0x03 || v.Subtype == 0x04) && len(v.Data) == 16 {
+			d := v.Data
+			return fmt.Sprintf("%x-%x-%x-%x-%x", d[0:4], d[4:6], d[6:8], d[8:10], d[10:16])
+		}
+		return hex.EncodeToString(v.Data)
+	}
+	data, err = bson.MarshalExtJSON(bson.D{{Key: "_id", Value: sortedValue(doc["_id"])}}, false, false)
+	if err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	// Unwrap {"_id":...}
+	return string(data[len(`{"_id":`) : len(data)-1])
+}
+
+// sortedValue returns v with the fields of its embedded documents sorted
+func sortedValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case bson.M:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		doc := make(bson.D, 0, len(keys))
+		for _, key := range keys {
+			doc = append(doc, bson.E{Key: key, Value: sortedValue(value[key])})
+		}
+		return doc
+	case bson.A:
+		values := make(bson.A, len(value))
+		for i, item := range value {
+			values[i] = sortedValue(item)
+		}
+		return values
+	}
+	return v
+}
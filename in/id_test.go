@@ -0,0 +1,37 @@
+package in
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestDocumentID(t *testing.T) {
+	oid := primitive.NewObjectID()
+	uuid := primitive.Binary{Subtype: 0x04, Data: []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}}
+	tests := []struct {
+		name string
+		id   interface{}
+		want string
+	}{
+		{name: "ObjectID", id: oid, want: oid.Hex()},
+		{name: "ObjectID pointer", id: &oid, want: oid.Hex()},
+		{name: "string", id: "user-1", want: "user-1"},
+		{name: "int", id: 42, want: "42"},
+		{name: "int32 and int64 alike", id: int64(42), want: "42"},
+		{name: "float", id: 1.5, want: "1.5"},
+		{name: "UUID", id: uuid, want: "123e4567-e89b-12d3-a456-426614174000"},
+		{name: "binary", id: primitive.Binary{Data: []byte{0xca, 0xfe}}, want: "cafe"},
+		{name: "compound", id: bson.D{{Key: "tenant", Value: "a"}, {Key: "n", Value: 1}}, want: `{"n":1,"tenant":"a"}`},
+		{name: "compound struct", id: struct {
+			Tenant string `bson:"tenant"`
+			N      int    `bson:"n"`
+		}{"a", 1}, want: `{"n":1,"tenant":"a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DocumentID(tt.id))
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package in
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Images are a written document as stored by the database before and after the write,
+// nil when unknown
+type Images struct {
+	Before bson.M
+	After  bson.M
+}
+
+type imagesKey struct{}
+
+// WithImages returns ctx carrying the images of the written document, db.NoSql
+// implementations pass them to PostSave
+func WithImages(ctx context.Context, images Images) context.Context {
+	return context.WithValue(ctx, imagesKey{}, images)
+}
+
+// ImagesFrom returns the images of the written document carried by the ctx of PostSave
+func ImagesFrom(ctx context.Context) (Images, bool) {
+	images, ok := ctx.Value(imagesKey{}).(Images)
+	return images, ok
+}
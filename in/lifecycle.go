@@ -0,0 +1,35 @@
+package in
+
+import "context"
+
+// Model lifecycle hooks, implemented by models with value or pointer receivers. They run in
+// addition to auditing: a Before hook returning an error vetoes the write, an After hook
+// error is handled like any PostSave failure. Delete hooks run for FindOneAndDelete, the
+// delete decoding the doc into a model, BeforeDelete once the doc is read and before it is
+// deleted. DeleteOne and DeleteMany only know the ids of the docs, their deletes and soft
+// deletes run no delete hook. Upserts and replaces only run the After hook of the insert or
+// update they turned out to be.
+
+type BeforeInsert interface {
+	BeforeInsert(ctx context.Context) error
+}
+
+type AfterInsert interface {
+	AfterInsert(ctx context.Context) error
+}
+
+type BeforeUpdate interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+type AfterUpdate interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+type BeforeDelete interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+type AfterDelete interface {
+	AfterDelete(ctx context.Context) error
+}
@@ -0,0 +1,17 @@
+package in
+
+import (
+	"context"
+	"github.com/its-own/gaudit/internal/entities"
+)
+
+// AuditLog is a single entry of the audit trail
+type AuditLog = entities.AuditLog
+
+// AuditChange holds the old and new value of a changed field
+type AuditChange = entities.AuditChange
+
+// Sink receives audit log entries right after they are written to the audit trail
+type Sink interface {
+	Send(ctx context.Context, logs []AuditLog) error
+}
@@ -0,0 +1,88 @@
+package in
+
+import (
+	"reflect"
+	"strings"
+)
+
+// VersionTag marks the version field of a model, an integer field such as
+//
+//	Version int64 `bson:"version" gaudit:"version"`
+//
+// An Update of a versioned model only applies to the stored doc having the model's version,
+// increments the version in the same write and in the model when it is a pointer, and fails
+// with db.ErrConflict otherwise. Audit logs record the version the write left.
+const VersionTag = "version"
+
+// VersionOf returns the bson name and the value of the version field of model, a struct or
+// a pointer to one. It reports false when model has no version field.
+func VersionOf(model interface{}) (field string, version int64, ok bool) {
+	v, field, ok := versionField(model)
+	if !ok {
+		return "", 0, false
+	}
+	if v.CanInt() {
+		return field, v.Int(), true
+	}
+	return field, int64(v.Uint()), true
+}
+
+// SetVersion sets the version field of model, a pointer to a struct, it reports false when
+// model has no settable version field
+func SetVersion(model interface{}, version int64) bool {
+	v, _, ok := versionField(model)
+	if !ok || !v.CanSet() {
+		return false
+	}
+	if v.CanInt() {
+		v.SetInt(version)
+	} else {
+		v.SetUint(uint64(version))
+	}
+	return true
+}
+
+// versionField finds the integer field tagged with VersionTag, in model or its inline structs
+func versionField(model interface{}) (reflect.Value, string, bool) {
+	v := reflect.ValueOf(model)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, "", false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, "", false
+	}
+	return findVersion(v)
+}
+
+func findVersion(v reflect.Value) (reflect.Value, string, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if f.Tag.Get("gaudit") == VersionTag {
+			switch f.Type.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			default:
+				continue
+			}
+			if name == "" {
+				// The driver stores untagged fields under their lowercased name
+				name = strings.ToLower(f.Name)
+			}
+			return v.Field(i), name, true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && strings.Contains(f.Tag.Get("bson"), "inline") {
+			if field, name, ok := findVersion(v.Field(i)); ok {
+				return field, name, true
+			}
+		}
+	}
+	return reflect.Value{}, "", false
+}
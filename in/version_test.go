@@ -0,0 +1,57 @@
+package in
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type versioned struct {
+	Name    string `bson:"name"`
+	Version int64  `bson:"rev" gaudit:"version"`
+}
+
+type embedsVersion struct {
+	versionBase `bson:",inline"`
+	Name        string `bson:"name"`
+}
+
+type versionBase struct {
+	Version uint32 `gaudit:"version"`
+}
+
+func TestVersionOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     interface{}
+		field     string
+		version   int64
+		versioned bool
+	}{
+		{name: "pointer", model: &versioned{Version: 3}, field: "rev", version: 3, versioned: true},
+		{name: "value", model: versioned{Version: 3}, field: "rev", version: 3, versioned: true},
+		{name: "inline struct", model: &embedsVersion{versionBase: versionBase{Version: 2}}, field: "version", version: 2, versioned: true},
+		{name: "no version field", model: &struct{ Name string }{}},
+		{name: "document", model: map[string]interface{}{"rev": 1}},
+		{name: "nil", model: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, version, ok := VersionOf(tt.model)
+			assert.Equal(t, tt.versioned, ok)
+			assert.Equal(t, tt.field, field)
+			assert.Equal(t, tt.version, version)
+		})
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	m := &versioned{Version: 1}
+	assert.True(t, SetVersion(m, 2))
+	assert.Equal(t, int64(2), m.Version)
+
+	e := &embedsVersion{}
+	assert.True(t, SetVersion(e, 5))
+	assert.Equal(t, uint32(5), e.Version)
+
+	assert.False(t, SetVersion(versioned{}, 2), "a value can't be set")
+}
@@ -0,0 +1,28 @@
+package in
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WriteTime is the cluster time window of a write: the change events of the documents it
+// wrote have a cluster time between From and To included. Audit logs store To, so the
+// change stream recognises the writes already audited by the hooks.
+type WriteTime struct {
+	From primitive.Timestamp
+	To   primitive.Timestamp
+}
+
+type writeTimeKey struct{}
+
+// WithWriteTime returns ctx carrying the cluster time window of the write, db.NoSql
+// implementations pass it to PostSave when the database reports it
+func WithWriteTime(ctx context.Context, t WriteTime) context.Context {
+	return context.WithValue(ctx, writeTimeKey{}, t)
+}
+
+// WriteTimeFrom returns the cluster time window of the write carried by the ctx of PostSave
+func WriteTimeFrom(ctx context.Context) (WriteTime, bool) {
+	t, ok := ctx.Value(writeTimeKey{}).(WriteTime)
+	return t, ok
+}
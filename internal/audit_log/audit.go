@@ -1,20 +1,24 @@
 package audit
 
-import (
-	"context"
-)
-
-// LogModels Registry for audit-log-enabled models
-var LogModels = make(map[string]bool)
+import "sync"
 
-func init() {
-	err := WatchAndInjectHooks(context.Background())
-	if err != nil {
-		panic(err)
-	}
-}
+// LogModels Registry for audit-log-enabled models, model types are the same for every
+// gaudit instance of the process. Use RegisterModel and IsRegistered to access it.
+var (
+	LogModels = make(map[string]bool)
+	mu        sync.RWMutex
+)
 
 // RegisterModel Register the model for audit logging
 func RegisterModel(key string) {
+	mu.Lock()
+	defer mu.Unlock()
 	LogModels[key] = true
 }
+
+// IsRegistered reports whether the model is registered for audit logging
+func IsRegistered(key string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return LogModels[key]
+}
@@ -0,0 +1,27 @@
+package models
+
+import "github.com/its-own/gaudit/in"
+
+// User is injectable
+type User struct {
+	in.Inject
+	Name string
+}
+
+// Order is injectable through a pointer
+type Order struct {
+	*in.Inject
+}
+
+// Alias isn't a named type of its own
+type Alias = struct {
+	in.Inject
+}
+
+// Plain isn't injectable
+type Plain struct {
+	Name string
+}
+
+// Broken doesn't type check, the scanner only reads the syntax
+var Broken = undefined
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
-	"go/types"
 	"golang.org/x/mod/modfile"
 	"golang.org/x/tools/go/packages"
 	"log"
@@ -15,7 +14,8 @@ import (
 	"strings"
 )
 
-// WatchAndInjectHooks finds structs with hookie.Inject and calls their hooks
+// WatchAndInjectHooks registers the structs with in.Inject of the project found from the
+// working directory. It fails when the working directory is outside a Go module.
 func WatchAndInjectHooks(ctx context.Context) error {
 	rootDir, err := findProjectRoot()
 	if err != nil {
@@ -23,7 +23,7 @@ func WatchAndInjectHooks(ctx context.Context) error {
 	}
 	goDirs, err := collectGoDirs(rootDir)
 	if err != nil {
-		log.Fatalf("Error collecting Go directories: %v", err)
+		return fmt.Errorf("error collecting Go directories: %w", err)
 	}
 	for _, dir := range goDirs {
 		err = WatchAndRegister(ctx, dir)
@@ -37,9 +37,24 @@ func WatchAndInjectHooks(ctx context.Context) error {
 // WatchAndRegister scans the specified directory for structs with in.Inject and registers their hooks.
 // It returns an error if any issues occur during processing.
 func WatchAndRegister(ctx context.Context, dir string) error {
+	models, err := Scan(dir)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		RegisterModel(model)
+	}
+	return nil // Return nil if processing completes without error
+}
+
+// Scan returns the registration keys of the structs with in.Inject found in the packages
+// matching pattern (a directory or a pattern such as ./...), without registering them.
+func Scan(pattern string) (models []string, err error) {
 	logger := slog.Default()
 	cfg := &packages.Config{
-		Mode: packages.NeedSyntax | packages.NeedTypes | packages.NeedImports,
+		// Only the syntax trees of the compiled Go files are needed to find injectable
+		// structs, type checking is skipped so loading does not depend on export data.
+		Mode: packages.NeedName | packages.NeedCompiledGoFiles | packages.NeedSyntax,
 	}
 
 	// Handle any potential panics gracefully
@@ -50,10 +65,10 @@ func WatchAndRegister(ctx context.Context, dir string) error {
 	}()
 
 	// Load the packages from the specified directory
-	_packages, err := packages.Load(cfg, dir)
+	_packages, err := packages.Load(cfg, pattern)
 	if err != nil {
-		logger.Error("Failed to load packages from directory", "directory", dir, "error", err)
-		return fmt.Errorf("unable to load packages from directory '%s': %w", dir, err) // Provide context in the returned error
+		logger.Error("Failed to load packages from directory", "directory", pattern, "error", err)
+		return nil, fmt.Errorf("unable to load packages from directory '%s': %w", pattern, err) // Provide context in the returned error
 	}
 
 	// Iterate over loaded packages
@@ -67,20 +82,11 @@ func WatchAndRegister(ctx context.Context, dir string) error {
 					for _, spec := range genDecl.Specs {
 						typeSpec := spec.(*ast.TypeSpec)
 						if structType, ok := typeSpec.Type.(*ast.StructType); ok {
-							if isInjectable(structType) {
+							// Aliases (type A = B) are not named types of their own
+							if isInjectable(structType) && !typeSpec.Assign.IsValid() {
 								structName := typeSpec.Name.Name
 								logger.Info("Found injectable struct", "structName: ", structName, "packagePath", pkg.ID)
-
-								// Lookup the full type from the package scope
-								typeObject := pkg.Types.Scope().Lookup(structName)
-								if typeObject == nil {
-									return fmt.Errorf("struct '%s' not found in package '%s': unable to retrieve its type information", structName, pkg.PkgPath)
-								}
-
-								// Ensure the object is of type *types.Named
-								if namedType, ok := typeObject.Type().(*types.Named); ok && namedType != nil {
-									RegisterModel(pkg.ID + structName)
-								}
+								models = append(models, pkg.ID+structName)
 							}
 						}
 					}
@@ -88,7 +94,7 @@ func WatchAndRegister(ctx context.Context, dir string) error {
 			}
 		}
 	}
-	return nil // Return nil if processing completes without error
+	return models, nil
 }
 
 // isInjectable checks if a given struct type contains a field of type "Inject".
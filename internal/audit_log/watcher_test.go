@@ -1,7 +1,20 @@
 package audit
 
-import "testing"
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
 
 func Test_getGoModuleName(t *testing.T) {
 
 }
+
+func TestScan(t *testing.T) {
+	models, err := Scan("./testdata/models")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"github.com/its-own/gaudit/internal/audit_log/testdata/modelsUser",
+		"github.com/its-own/gaudit/internal/audit_log/testdata/modelsOrder",
+	}, models)
+}
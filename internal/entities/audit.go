@@ -8,11 +8,16 @@ import (
 type AuditLogMeta struct {
 	Id                   primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
 	DocumentCurrentState map[string]interface{} `json:"document_current_state,omitempty" bson:"document_current_state,omitempty"`
+	// ClusterTime is the cluster time of the last audited write of the document, see in.WriteTime
+	ClusterTime primitive.Timestamp `json:"cluster_time,omitempty" bson:"cluster_time,omitempty"`
 }
 
 type AuditLog struct {
 	Id             primitive.ObjectID     `json:"id,omitempty" bson:"_id,omitempty"`
 	AuditMetaId    string                 `json:"audit_meta_id,omitempty" bson:"audit_meta_id,omitempty"`
+	Collection     string                 `json:"collection,omitempty" bson:"collection,omitempty"`
+	Operation      string                 `json:"operation,omitempty" bson:"operation,omitempty"`
+	DocumentId     string                 `json:"document_id,omitempty" bson:"document_id,omitempty"`
 	AuditEvent     string                 `json:"audit_event,omitempty" bson:"audit_event,omitempty"`
 	AuditURL       string                 `json:"audit_url,omitempty" bson:"audit_url,omitempty"`
 	AuditIPAddress string                 `json:"audit_ip_address,omitempty" bson:"audit_ip_address,omitempty"`
@@ -23,6 +28,10 @@ type AuditLog struct {
 	UserID         string                 `json:"user_id,omitempty" bson:"user_id,omitempty"`
 	UserType       string                 `json:"user_type,omitempty" bson:"user_type,omitempty"`
 	Change         map[string]AuditChange `json:"change,omitempty" bson:"change,omitempty"`
+	// Version is the version of a versioned model after the write, see in.VersionTag
+	Version *int64 `json:"version,omitempty" bson:"version,omitempty"`
+	// ClusterTime is the cluster time of the audited write when the database reports it, see in.WriteTime
+	ClusterTime *primitive.Timestamp `json:"cluster_time,omitempty" bson:"cluster_time,omitempty"`
 }
 
 type AuditChange struct {
@@ -0,0 +1,224 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/internal/entities"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// List of errors
+var (
+	ErrQueueFull = errors.New("audit: queue is full")
+	ErrClosed    = errors.New("audit: writer is closed")
+)
+
+// Backpressure decides what happens to an audit log when the queue is full
+type Backpressure int
+
+const (
+	// Block makes the write wait until there is room in the queue
+	Block Backpressure = iota
+	// Drop discards the audit log and reports it to AsyncOptions.OnDrop
+	Drop
+)
+
+// AsyncOptions configures the background audit writer, zero values fall back to defaults
+type AsyncOptions struct {
+	Workers       int           // number of workers, 4 by default
+	QueueSize     int           // audit logs buffered across all workers, 1024 by default
+	BatchSize     int           // audit logs written per bulk write, 100 by default
+	FlushInterval time.Duration // longest time an audit log waits in the queue, 500ms by default
+	Backpressure  Backpressure
+	OnDrop        func(log entities.AuditLog)
+}
+
+// asyncWriter queues records and applies them in batches from a pool of workers.
+// Records of one document always go to the same worker, so they are applied in order.
+type asyncWriter struct {
+	l       *slog.Logger
+	opts    AsyncOptions
+	apply   func(ctx context.Context, recs []record) error
+	shards  []chan record
+	flushes []chan chan struct{}
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+}
+
+func newAsyncWriter(l *slog.Logger, opts AsyncOptions, apply func(ctx context.Context, recs []record) error) *asyncWriter {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 500 * time.Millisecond
+	}
+	size := opts.QueueSize / opts.Workers
+	if size < 1 {
+		size = 1
+	}
+	w := &asyncWriter{
+		l:       l,
+		opts:    opts,
+		apply:   apply,
+		shards:  make([]chan record, opts.Workers),
+		flushes: make([]chan chan struct{}, opts.Workers),
+		done:    make(chan struct{}),
+	}
+	for i := range w.shards {
+		w.shards[i] = make(chan record, size)
+		w.flushes[i] = make(chan chan struct{})
+		w.wg.Add(1)
+		go w.run(w.shards[i], w.flushes[i])
+	}
+	return w
+}
+
+func (w *asyncWriter) write(ctx context.Context, rec record) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	shard := w.shards[w.shardOf(rec)]
+	if w.opts.Backpressure == Drop {
+		select {
+		case shard <- rec:
+			return nil
+		default:
+			w.dropped.Add(1)
+			if w.opts.OnDrop != nil {
+				w.opts.OnDrop(rec.log)
+			}
+			return fmt.Errorf("%w: %d audit logs dropped so far", ErrQueueFull, w.dropped.Load())
+		}
+	}
+	select {
+	case shard <- rec:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush asks every worker to apply its queue and waits for all of them
+func (w *asyncWriter) flush(ctx context.Context) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+
+	acks := make([]chan struct{}, 0, len(w.flushes))
+	for _, flush := range w.flushes {
+		ack := make(chan struct{})
+		select {
+		case flush <- ack:
+			acks = append(acks, ack)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, ack := range acks {
+		select {
+		case <-ack:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// close stops accepting records and waits until the queued ones are applied
+func (w *asyncWriter) close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+	w.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *asyncWriter) shardOf(rec record) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(rec.log.Collection))
+	_, _ = hash.Write([]byte(rec.log.DocumentId))
+	return int(hash.Sum32() % uint32(len(w.shards)))
+}
+
+// run batches the records of one shard until the writer is closed
+func (w *asyncWriter) run(queue chan record, flushes chan chan struct{}) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]record, 0, w.opts.BatchSize)
+	apply := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Writes outlive the request that queued them, so they don't inherit its context
+		if err := w.apply(context.Background(), batch); err != nil {
+			w.l.Error(fmt.Sprintf("Failed to write %d audit logs: %v", len(batch), err))
+		}
+		batch = make([]record, 0, w.opts.BatchSize)
+	}
+	add := func(rec record) {
+		batch = append(batch, rec)
+		if len(batch) >= w.opts.BatchSize {
+			apply()
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case rec := <-queue:
+				add(rec)
+			default:
+				apply()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case rec := <-queue:
+			add(rec)
+		case <-ticker.C:
+			apply()
+		case ack := <-flushes:
+			drain()
+			close(ack)
+		case <-w.done:
+			drain()
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package hooks
+
+import (
+	"context"
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder collects the batches applied by an asyncWriter
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]record
+	block   chan struct{}
+}
+
+func (b *batchRecorder) apply(_ context.Context, recs []record) error {
+	if b.block != nil {
+		<-b.block
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches = append(b.batches, append([]record(nil), recs...))
+	return nil
+}
+
+func (b *batchRecorder) docIds() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for _, batch := range b.batches {
+		for _, rec := range batch {
+			ids = append(ids, rec.log.DocumentId)
+		}
+	}
+	return ids
+}
+
+func newRecord(docId string) record {
+	return record{log: entities.AuditLog{Collection: "user", Operation: "update", DocumentId: docId}}
+}
+
+func TestAsyncWriter_BatchesBySize(t *testing.T) {
+	rec := &batchRecorder{}
+	w := newAsyncWriter(slog.Default(), AsyncOptions{Workers: 1, BatchSize: 2, FlushInterval: time.Hour}, rec.apply)
+
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, w.write(ctx, newRecord(id)))
+	}
+	require.NoError(t, w.close(ctx))
+
+	require.Len(t, rec.batches, 2)
+	assert.Len(t, rec.batches[0], 2)
+	assert.Len(t, rec.batches[1], 1)
+	assert.Equal(t, []string{"1", "2", "3"}, rec.docIds())
+}
+
+func TestAsyncWriter_FlushInterval(t *testing.T) {
+	rec := &batchRecorder{}
+	w := newAsyncWriter(slog.Default(), AsyncOptions{Workers: 1, FlushInterval: 10 * time.Millisecond}, rec.apply)
+	defer w.close(context.Background())
+
+	require.NoError(t, w.write(context.Background(), newRecord("1")))
+	assert.Eventually(t, func() bool { return len(rec.docIds()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestAsyncWriter_Flush(t *testing.T) {
+	rec := &batchRecorder{}
+	w := newAsyncWriter(slog.Default(), AsyncOptions{Workers: 3, FlushInterval: time.Hour}, rec.apply)
+
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, w.write(ctx, newRecord(id)))
+	}
+	require.NoError(t, w.flush(ctx))
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4"}, rec.docIds())
+
+	require.NoError(t, w.close(ctx))
+	assert.ErrorIs(t, w.write(ctx, newRecord("5")), ErrClosed)
+	assert.ErrorIs(t, w.flush(ctx), ErrClosed)
+}
+
+func TestAsyncWriter_KeepsDocumentOrder(t *testing.T) {
+	rec := &batchRecorder{}
+	w := newAsyncWriter(slog.Default(), AsyncOptions{Workers: 4, BatchSize: 3}, rec.apply)
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		r := newRecord("same")
+		r.state = map[string]interface{}{"n": i}
+		require.NoError(t, w.write(ctx, r))
+	}
+	require.NoError(t, w.close(ctx))
+
+	var got []interface{}
+	for _, batch := range rec.batches {
+		for _, r := range batch {
+			got = append(got, r.state["n"])
+		}
+	}
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+}
+
+func TestAsyncWriter_DropWhenFull(t *testing.T) {
+	rec := &batchRecorder{block: make(chan struct{})}
+	var dropped []string
+	w := newAsyncWriter(slog.Default(), AsyncOptions{
+		Workers:       1,
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		Backpressure:  Drop,
+		OnDrop:        func(log entities.AuditLog) { dropped = append(dropped, log.DocumentId) },
+	}, rec.apply)
+
+	ctx := context.Background()
+	// The worker takes the first record and blocks on it, the second fills the queue
+	require.NoError(t, w.write(ctx, newRecord("1")))
+	assert.Eventually(t, func() bool { return len(w.shards[0]) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, w.write(ctx, newRecord("2")))
+	assert.ErrorIs(t, w.write(ctx, newRecord("3")), ErrQueueFull)
+	assert.Equal(t, []string{"3"}, dropped)
+	assert.EqualValues(t, 1, w.dropped.Load())
+
+	close(rec.block)
+	require.NoError(t, w.close(ctx))
+	assert.Equal(t, []string{"1", "2"}, rec.docIds())
+}
+
+func TestAsyncWriter_BlockWhenFull(t *testing.T) {
+	rec := &batchRecorder{block: make(chan struct{})}
+	w := newAsyncWriter(slog.Default(), AsyncOptions{Workers: 1, QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour}, rec.apply)
+
+	ctx := context.Background()
+	require.NoError(t, w.write(ctx, newRecord("1")))
+	assert.Eventually(t, func() bool { return len(w.shards[0]) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, w.write(ctx, newRecord("2")))
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.write(timeout, newRecord("3")), context.DeadlineExceeded)
+
+	close(rec.block)
+	require.NoError(t, w.close(ctx))
+	assert.Equal(t, []string{"1", "2"}, rec.docIds())
+}
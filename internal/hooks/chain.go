@@ -0,0 +1,191 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	in "github.com/its-own/gaudit/in"
+	"reflect"
+	"time"
+)
+
+// FailureMode decides what happens when a PostSave hook fails outside a transaction.
+// Inside a transaction a failure is always returned, so the write is rolled back.
+type FailureMode int
+
+const (
+	// LogFailure logs the failure, the caller sees a successful write
+	LogFailure FailureMode = iota
+	// SurfaceFailure returns the failure to the caller, wrapped with db.ErrAuditFailed
+	SurfaceFailure
+	// RetryFailure retries the failed hook, then logs the failure
+	RetryFailure
+)
+
+// FailurePolicy is applied to each failing PostSave hook, the audit hook included
+type FailurePolicy struct {
+	Mode FailureMode
+	// Retries is the number of retries of RetryFailure, 3 by default
+	Retries int
+	// Backoff is the wait before the first retry, doubled on every retry, 100ms by default
+	Backoff time.Duration
+}
+
+// Chain composes custom hooks with the audit hook. PreSave runs the global hooks, then
+// the hooks of the collection, then the hooks of the model, and the audit hook last: the
+// first error vetoes the write and the remaining hooks are skipped. PostSave writes the
+// audit log first, then runs the hooks of the model itself, then the custom hooks in the
+// same order; every step runs and its failure is handled by the FailurePolicy.
+type Chain struct {
+	audit       *DefaultHooks
+	global      []in.HookV2
+	collections map[string][]in.HookV2
+	models      map[reflect.Type][]in.HookV2
+	policy      FailurePolicy
+}
+
+// NewChain returns a chain running only the audit hook
+func NewChain(audit *DefaultHooks) *Chain {
+	return &Chain{
+		audit:       audit,
+		collections: make(map[string][]in.HookV2),
+		models:      make(map[reflect.Type][]in.HookV2),
+	}
+}
+
+// WithFailurePolicy sets how PostSave failures are handled, failures are logged by default
+func (c *Chain) WithFailurePolicy(policy FailurePolicy) *Chain {
+	if policy.Retries <= 0 {
+		policy.Retries = 3
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = 100 * time.Millisecond
+	}
+	c.policy = policy
+	return c
+}
+
+// Use appends hooks running on every write
+func (c *Chain) Use(hooks ...in.HookV2) *Chain {
+	c.global = append(c.global, hooks...)
+	return c
+}
+
+// UseForCollection appends hooks running on writes to col
+func (c *Chain) UseForCollection(col string, hooks ...in.HookV2) *Chain {
+	c.collections[col] = append(c.collections[col], hooks...)
+	return c
+}
+
+// UseForModel appends hooks running on writes of model's type, pointer or not
+func (c *Chain) UseForModel(model interface{}, hooks ...in.HookV2) *Chain {
+	if t := modelType(model); t != nil {
+		c.models[t] = append(c.models[t], hooks...)
+	}
+	return c
+}
+
+func (c *Chain) PreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	for _, h := range c.hooksFor(model, col) {
+		if err := h.PreSave(ctx, model, filter, col, ops, docId); err != nil {
+			return err
+		}
+	}
+	return c.audit.PreSave(ctx, model, filter, col, ops, docId)
+}
+
+func (c *Chain) PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	var errs []error
+	// Retries of the audit step write the same audit log, see withWriteID
+	ctx = withWriteID(ctx)
+	audit := func() error { return c.audit.writeAudit(ctx, model, col, ops, docId) }
+	if err := c.handle(ctx, "audit", audit); err != nil {
+		errs = append(errs, err)
+	}
+	// The hooks of the model are a step of their own, retrying them doesn't audit the write again
+	modelPostSave := func() error { return runModelPostSave(ctx, model, filter, col, ops, docId) }
+	if err := c.handle(ctx, "model PostSave", modelPostSave); err != nil {
+		errs = append(errs, err)
+	}
+	for _, h := range c.hooksFor(model, col) {
+		postSave := func() error { return h.PostSave(ctx, model, filter, col, ops, docId) }
+		if err := c.handle(ctx, "PostSave", postSave); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", db.ErrAuditFailed, errors.Join(errs...))
+}
+
+// handle runs a PostSave step and applies the failure policy, it returns the failures
+// that must reach the caller
+func (c *Chain) handle(ctx context.Context, step string, run func() error) error {
+	err := run()
+	if err == nil {
+		return nil
+	}
+	if inTx(ctx) || c.policy.Mode == SurfaceFailure {
+		return err
+	}
+	if c.policy.Mode == RetryFailure {
+		backoff := c.policy.Backoff
+	retry:
+		for attempt := 1; attempt <= c.policy.Retries && err != nil; attempt++ {
+			select {
+			case <-ctx.Done():
+				break retry
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			err = run()
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	c.audit.l.Error(fmt.Sprintf("%s hook failed: %v", step, err))
+	return nil
+}
+
+// Flush waits until every pending audit log is written
+func (c *Chain) Flush(ctx context.Context) error {
+	return c.audit.Flush(ctx)
+}
+
+// Close stops the audit hook, see DefaultHooks.Close
+func (c *Chain) Close(ctx context.Context) error {
+	return c.audit.Close(ctx)
+}
+
+// BeginTx see DefaultHooks.BeginTx
+func (c *Chain) BeginTx(ctx context.Context) context.Context {
+	return c.audit.BeginTx(ctx)
+}
+
+// CommitTx see DefaultHooks.CommitTx
+func (c *Chain) CommitTx(ctx context.Context) {
+	c.audit.CommitTx(ctx)
+}
+
+// hooksFor returns the custom hooks applying to a write of model to col, in running order
+func (c *Chain) hooksFor(model interface{}, col string) []in.HookV2 {
+	hooks := make([]in.HookV2, 0, len(c.global))
+	hooks = append(hooks, c.global...)
+	hooks = append(hooks, c.collections[col]...)
+	if t := modelType(model); t != nil {
+		hooks = append(hooks, c.models[t]...)
+	}
+	return hooks
+}
+
+// modelType returns the type of model, dereferencing pointers
+func modelType(model interface{}) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
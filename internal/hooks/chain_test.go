@@ -0,0 +1,167 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"github.com/its-own/gaudit/db"
+	in "github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// traceHook records its calls into a shared trace and fails on demand
+type traceHook struct {
+	name    string
+	trace   *[]string
+	preErr  error
+	postErr error
+}
+
+func (h *traceHook) PreSave(_ context.Context, _ interface{}, _ interface{}, _, _, _ string) error {
+	*h.trace = append(*h.trace, "pre:"+h.name)
+	return h.preErr
+}
+
+func (h *traceHook) PostSave(_ context.Context, _ interface{}, _ interface{}, _, _, _ string) error {
+	*h.trace = append(*h.trace, "post:"+h.name)
+	return h.postErr
+}
+
+type chainModel struct{}
+type otherModel struct{}
+
+func TestChain_Order(t *testing.T) {
+	var trace []string
+	chain := NewChain(NewDefaultHook(slog.Default(), nil)).
+		Use(&traceHook{name: "global", trace: &trace}).
+		UseForCollection("user", &traceHook{name: "user", trace: &trace}).
+		UseForCollection("order", &traceHook{name: "order", trace: &trace}).
+		UseForModel(chainModel{}, &traceHook{name: "model", trace: &trace}).
+		UseForModel(&otherModel{}, &traceHook{name: "other", trace: &trace})
+
+	ctx := context.Background()
+	assert.NoError(t, chain.PreSave(ctx, &chainModel{}, nil, "user", "insert", ""))
+	assert.NoError(t, chain.PostSave(ctx, &chainModel{}, nil, "user", "insert", "1"))
+	assert.Equal(t, []string{"pre:global", "pre:user", "pre:model", "post:global", "post:user", "post:model"}, trace)
+
+	// Deletes carry no model, only global and collection hooks apply
+	trace = nil
+	assert.NoError(t, chain.PreSave(ctx, nil, nil, "order", "delete", ""))
+	assert.Equal(t, []string{"pre:global", "pre:order"}, trace)
+}
+
+func TestChain_PreSaveVeto(t *testing.T) {
+	var trace []string
+	denied := errors.New("denied")
+	chain := NewChain(NewDefaultHook(slog.Default(), nil)).
+		Use(&traceHook{name: "auth", trace: &trace, preErr: denied}).
+		UseForCollection("user", &traceHook{name: "user", trace: &trace})
+
+	err := chain.PreSave(context.Background(), &chainModel{}, nil, "user", "update", "")
+	assert.ErrorIs(t, err, denied)
+	assert.Equal(t, []string{"pre:auth"}, trace, "hooks after the veto don't run")
+}
+
+func TestChain_PostSaveErrors(t *testing.T) {
+	var trace []string
+	first, second := errors.New("first"), errors.New("second")
+	chain := NewChain(NewDefaultHook(slog.Default(), nil)).
+		Use(&traceHook{name: "a", trace: &trace, postErr: first}, &traceHook{name: "b", trace: &trace, postErr: second}).
+		WithFailurePolicy(FailurePolicy{Mode: SurfaceFailure})
+
+	err := chain.PostSave(context.Background(), &chainModel{}, nil, "user", "update", "1")
+	assert.ErrorIs(t, err, db.ErrAuditFailed)
+	assert.ErrorIs(t, err, first)
+	assert.ErrorIs(t, err, second)
+	assert.Equal(t, []string{"post:a", "post:b"}, trace, "every hook runs")
+}
+
+func TestChain_FailurePolicy(t *testing.T) {
+	failing := errors.New("failing")
+	tests := []struct {
+		name      string
+		policy    FailurePolicy
+		failures  int
+		inTx      bool
+		wantErr   bool
+		wantCalls int
+	}{
+		{"Logged by default", FailurePolicy{}, 1, false, false, 1},
+		{"Surfaced", FailurePolicy{Mode: SurfaceFailure}, 1, false, true, 1},
+		{"Retried until success", FailurePolicy{Mode: RetryFailure, Backoff: time.Millisecond}, 2, false, false, 3},
+		{"Retried then logged", FailurePolicy{Mode: RetryFailure, Retries: 2, Backoff: time.Millisecond}, 5, false, false, 3},
+		{"Always surfaced in a transaction", FailurePolicy{Mode: LogFailure}, 1, true, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &flakyHook{failures: tt.failures, err: failing}
+			audit := NewDefaultHook(slog.Default(), nil)
+			chain := NewChain(audit).Use(h).WithFailurePolicy(tt.policy)
+
+			ctx := context.Background()
+			if tt.inTx {
+				ctx = audit.BeginTx(ctx)
+			}
+			err := chain.PostSave(ctx, &chainModel{}, nil, "user", "update", "1")
+			if tt.wantErr {
+				assert.ErrorIs(t, err, failing)
+				assert.ErrorIs(t, err, db.ErrAuditFailed)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, h.calls)
+		})
+	}
+}
+
+// flakyHook fails its first PostSave calls
+type flakyHook struct {
+	failures int
+	calls    int
+	err      error
+}
+
+func (h *flakyHook) PreSave(context.Context, interface{}, interface{}, string, string, string) error {
+	return nil
+}
+
+func (h *flakyHook) PostSave(context.Context, interface{}, interface{}, string, string, string) error {
+	h.calls++
+	if h.calls <= h.failures {
+		return h.err
+	}
+	return nil
+}
+
+// flakyModel is an audited model whose AfterUpdate fails its first calls
+type flakyModel struct {
+	in.Inject
+	ID       string `bson:"_id"`
+	Name     string `bson:"name"`
+	failures int
+	calls    int
+}
+
+func (m *flakyModel) AfterUpdate(context.Context) error {
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("failing")
+	}
+	return nil
+}
+
+func TestChain_RetryModelHooks(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksflakyModel")
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
+	chain := NewChain(h).WithFailurePolicy(FailurePolicy{Mode: RetryFailure, Backoff: time.Millisecond})
+
+	model := &flakyModel{ID: "1", Name: "a", failures: 2}
+	assert.NoError(t, chain.PostSave(context.Background(), model, nil, "user", "update", "1"))
+	assert.Equal(t, 3, model.calls)
+	assert.Len(t, w.recs, 1, "retrying the model's hook doesn't audit the write again")
+}
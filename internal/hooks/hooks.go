@@ -6,99 +6,208 @@ import (
 	in "github.com/its-own/gaudit/in"
 	"github.com/its-own/gaudit/internal/audit_log"
 	"github.com/its-own/gaudit/internal/entities"
-	"github.com/its-own/gaudit/internal/infracture/db/mongo"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
 	"reflect"
-	"strings"
+	"sync"
 	"time"
-	"unicode"
 )
 
 type DefaultHooks struct {
-	l *slog.Logger
+	l        *slog.Logger
+	db       *driver.Database
+	storage  StorageOptions
+	store    AuditStore
+	redact   map[string]map[string]bool
+	sinks    []in.Sink
+	w        writer
+	jobs     sync.WaitGroup
+	jobsCtx  context.Context
+	stopJobs context.CancelFunc
+	jobsOnce sync.Once
 }
 
-func NewDefaultHook(l *slog.Logger) *DefaultHooks {
-	return &DefaultHooks{l: l}
+// NewDefaultHook returns the audit hook writing the audit trail to db, see WithStorage to
+// store it elsewhere. Every written audit log is forwarded to sinks
+func NewDefaultHook(l *slog.Logger, db *driver.Database, sinks ...in.Sink) *DefaultHooks {
+	if l == nil {
+		l = slog.Default()
+	}
+	h := &DefaultHooks{l: l, db: db, sinks: sinks}
+	h.WithStorage(StorageOptions{})
+	h.w = &syncWriter{apply: h.apply}
+	return h
 }
 
-func (h *DefaultHooks) PreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) {
-	// Trigger PreSave hook if defined by user, else run default
-	if hasPreSaveHook(model) {
-		model.(in.Hook).PreSave(ctx, model, filter, col, ops, docId)
-		return
+// NewAsyncHook returns the audit hook writing audit logs in the background, see AsyncOptions
+func NewAsyncHook(l *slog.Logger, db *driver.Database, opts AsyncOptions, sinks ...in.Sink) *DefaultHooks {
+	h := NewDefaultHook(l, db, sinks...)
+	h.w = newAsyncWriter(h.l, opts, h.apply)
+	return h
+}
+
+// PreSave runs the hooks defined by the model itself: its in.Hook or in.HookV2 PreSave,
+// then its Before<Operation> lifecycle hook. An error vetoes the write.
+func (h *DefaultHooks) PreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	if err := runModelPreSave(ctx, model, filter, col, ops, docId); err != nil {
+		return err
 	}
 	h.l.Info("default PreSave hook triggered")
+	return nil
 }
 
-func (h *DefaultHooks) PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) {
-	if hasPostSaveHook(model) {
-		model.(in.Hook).PostSave(ctx, model, filter, col, ops, docId)
-		return
+// PostSave writes the audit log of the write, then runs the hooks defined by the model
+// itself: its in.Hook or in.HookV2 PostSave, then its After<Operation> lifecycle hook.
+// Model hooks run in addition to the audit, never instead of it.
+func (h *DefaultHooks) PostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	if err := h.writeAudit(ctx, model, col, ops, docId); err != nil {
+		return err
 	}
+	return runModelPostSave(ctx, model, filter, col, ops, docId)
+}
 
-	if isAuditLogEnabled(model) {
-		switch ops {
-		case "insert":
-			h.handleInsertOperation(ctx, model)
-		case "update":
-			h.handleUpdateOperation(ctx, model, docId)
-		}
+// writeAudit writes the audit log of the write, without running the hooks of the model
+func (h *DefaultHooks) writeAudit(ctx context.Context, model interface{}, col, ops, docId string) error {
+	var err error
+	switch {
+	case ops == "delete":
+		// Deleted documents are audited if their state is tracked, the model is only known to FindOneAndDelete
+		log := newAuditLog(ctx, col, ops, docId)
+		log.Version = versionOf(model, nil)
+		err = h.writeRecord(ctx, record{log: log})
+	case isAuditLogEnabled(model) && (ops == "insert" || ops == "update"):
+		err = h.handleOperation(ctx, model, col, ops, docId)
+	case (ops == "upsert" || ops == "replace") && (isAuditLogEnabled(model) || isUpdateDocument(model)):
+		err = h.handleUpsert(ctx, model, col, ops, docId)
+	case ops == "update" && isUpdateDocument(model):
+		// Partial updates carry no model, they are audited if the document's state is tracked
+		err = h.handleOperation(ctx, model, col, ops, docId)
+	case ops == "soft_delete" || ops == "restore":
+		err = h.handleSoftDelete(ctx, col, ops, docId)
+	}
+	if err != nil {
+		return err
 	}
 	h.l.Info("default PostSave hook triggered")
+	return nil
 }
 
-// handleInsertOperation manages audit logging during insert operations.
-func (h *DefaultHooks) handleInsertOperation(ctx context.Context, model interface{}) {
-	db := mongo.GetDbConnection()
-	state, err := structToMap(model)
-	if err != nil {
-		h.l.Error(err.Error())
-		return
-	}
+// Flush waits until every pending audit log is written
+func (h *DefaultHooks) Flush(ctx context.Context) error {
+	return h.w.flush(ctx)
+}
 
-	auditLogMeta := entities.AuditLogMeta{
-		Id:                   primitive.NewObjectID(),
-		DocumentCurrentState: state,
+// Close stops the background jobs (change streams, retention), writes the pending audit logs
+// and stops accepting new ones. Sinks implementing Close(ctx) error are closed last.
+func (h *DefaultHooks) Close(ctx context.Context) error {
+	if h.stopJobs != nil {
+		h.stopJobs()
+		h.jobs.Wait()
 	}
-
-	_, err = db.Database.Collection("audit_logs_meta").InsertOne(ctx, auditLogMeta)
-	if err != nil {
-		h.l.Error(err.Error())
+	if err := h.w.close(ctx); err != nil {
+		return err
 	}
+	for _, sink := range h.sinks {
+		if c, ok := sink.(interface {
+			Close(ctx context.Context) error
+		}); ok {
+			if err := c.Close(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-// handleUpdateOperation manages audit logging during update operations.
-func (h *DefaultHooks) handleUpdateOperation(ctx context.Context, model interface{}, docId string) {
-	db := mongo.GetDbConnection()
+// background runs job in a goroutine, its context is cancelled when the hook is closed
+func (h *DefaultHooks) background(job func(ctx context.Context)) {
+	h.jobsOnce.Do(func() {
+		h.jobsCtx, h.stopJobs = context.WithCancel(context.Background())
+	})
+	h.jobs.Add(1)
+	go func() {
+		defer h.jobs.Done()
+		job(h.jobsCtx)
+	}()
+}
 
-	// Retrieve the existing audit log meta by document ID
-	auditLogMeta, err := h.findAuditLogMeta(ctx, db, docId)
+// handleOperation snapshots the document state and hands the audit record to the writer.
+func (h *DefaultHooks) handleOperation(ctx context.Context, model interface{}, col, ops, docId string) error {
+	log := newAuditLog(ctx, col, ops, docId)
+	if ops == "update" {
+		// The stored document is the most accurate state, defaults and concurrent changes included
+		if images, ok := in.ImagesFrom(ctx); ok && images.After != nil {
+			log.Version = versionOf(model, images.After)
+			return h.writeRecord(ctx, record{log: log, state: documentToState(images.After),
+				before: documentToState(images.Before), quiet: isUpdateDocument(model)})
+		}
+		// An update only sets the fields of its payload, the others keep their tracked value
+		fields, err := updateFields(model)
+		if err != nil {
+			return fmt.Errorf("failed to convert update to map: %w", err)
+		}
+		log.Version = versionOf(model, fields)
+		return h.writeRecord(ctx, record{log: log, state: fields, merge: true, quiet: isUpdateDocument(model)})
+	}
+	// The state is the document as stored, so later diffs compare like with like
+	state, err := modelToState(model)
 	if err != nil {
-		h.l.Error(fmt.Sprintf("Failed to find audit log meta: %v", err))
-		return
+		return fmt.Errorf("failed to convert model to map: %w", err)
 	}
+	// The _id may have been generated by the database, the state is tracked by docId
+	state["_id"] = docId
+	log.Version = versionOf(model, state)
+	return h.writeRecord(ctx, record{log: log, state: state})
+}
 
-	// Convert the new document state to a map
-	newDoc, err := structToMap(model)
-	if err != nil {
-		h.l.Error(fmt.Sprintf("Failed to convert model to map: %v", err))
-		return
+// handleUpsert audits an upsert or a replace as the insert or the update it turned out to be,
+// told apart by the before image of the document. The audit event is ops.
+func (h *DefaultHooks) handleUpsert(ctx context.Context, model interface{}, col, ops, docId string) error {
+	images, ok := in.ImagesFrom(ctx)
+	if !ok || images.After == nil {
+		return fmt.Errorf("%s of document %s in %s carries no stored document", ops, docId, col)
+	}
+	log := newAuditLog(ctx, col, "update", docId)
+	log.AuditEvent = ops
+	log.Version = versionOf(model, images.After)
+	if images.Before == nil {
+		// The model of a partial document is unknown, so is whether its collection is audited
+		if isUpdateDocument(model) {
+			return nil
+		}
+		// The inserted document is the baseline of its future updates
+		log.Operation = "insert"
+		return h.writeRecord(ctx, record{log: log, state: documentToState(images.After)})
 	}
+	return h.writeRecord(ctx, record{log: log, state: documentToState(images.After),
+		before: documentToState(images.Before), quiet: isUpdateDocument(model)})
+}
 
-	// Compare document states and log changes
-	changeLog := compareDocumentStates(auditLogMeta.DocumentCurrentState, newDoc)
-	if err := h.logAuditChanges(ctx, db, changeLog); err != nil {
-		h.l.Error(fmt.Sprintf("Failed to log audit changes: %v", err))
-		return
+// handleSoftDelete audits the soft delete or the restore of a document as an update of its
+// deleted_at field, the audit event is ops. Like deletes, they carry no model and are audited
+// if the document's state is tracked.
+func (h *DefaultHooks) handleSoftDelete(ctx context.Context, col, ops, docId string) error {
+	images, ok := in.ImagesFrom(ctx)
+	if !ok || images.After == nil {
+		return fmt.Errorf("%s of document %s in %s carries no stored document", ops, docId, col)
 	}
+	log := newAuditLog(ctx, col, "update", docId)
+	log.AuditEvent = ops
+	return h.writeRecord(ctx, record{log: log, state: documentToState(images.After),
+		before: documentToState(images.Before), quiet: true})
+}
 
-	// Update the audit meta with the new document state
-	if err := h.updateAuditLogMeta(ctx, db, auditLogMeta.Id, newDoc); err != nil {
-		h.l.Error(fmt.Sprintf("Failed to update audit log meta: %v", err))
+// writeRecord hands rec to the writer, inside a transaction it is applied right away, within the transaction.
+func (h *DefaultHooks) writeRecord(ctx context.Context, rec record) error {
+	if written, ok := in.WriteTimeFrom(ctx); ok {
+		rec.written = written
+		rec.log.ClusterTime = &written.To
 	}
+	if inTx(ctx) {
+		return h.apply(ctx, []record{rec})
+	}
+	return h.w.write(ctx, rec)
 }
 
 // Helper function to retrieve a value from the context and handle missing data.
@@ -110,25 +219,33 @@ func getContextValue(ctx context.Context, key string) string {
 	return value
 }
 
-// findAuditLogMeta retrieves the existing audit log meta by document ID.
-func (h *DefaultHooks) findAuditLogMeta(ctx context.Context, db *mongo.Mongo, docId string) (entities.AuditLogMeta, error) {
-	var auditLogMeta entities.AuditLogMeta
-	auditFilter := bson.D{{"document_current_state._id", docId}}
+// writeIDKey holds the id of the audit log of a write, see withWriteID
+type writeIDKey struct{}
 
-	err := db.Database.Collection("audit_logs_meta").FindOne(ctx, auditFilter).Decode(&auditLogMeta)
-	if err != nil {
-		return auditLogMeta, fmt.Errorf("error finding audit log meta: %w", err)
+// withWriteID returns ctx carrying a new id for the audit log of a write. A retried audit of
+// the write builds the same audit log, so applying it again is idempotent.
+func withWriteID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeIDKey{}, primitive.NewObjectID())
+}
+
+// writeID returns the audit log id carried by ctx, a new id when there is none
+func writeID(ctx context.Context) primitive.ObjectID {
+	if id, ok := ctx.Value(writeIDKey{}).(primitive.ObjectID); ok {
+		return id
 	}
-	return auditLogMeta, nil
+	return primitive.NewObjectID()
 }
 
-// logAuditChanges inserts a new audit log entry for document changes.
-func (h *DefaultHooks) logAuditChanges(ctx context.Context, db *mongo.Mongo, changeLog map[string]entities.AuditChange) error {
+// newAuditLog builds the audit log entry of a single operation on document docId,
+// its meta id and changes are filled in when the entry is written.
+func newAuditLog(ctx context.Context, col, ops, docId string) entities.AuditLog {
 	currentTime := time.Now()
-
-	// Create the audit log entry
-	auditLog := entities.AuditLog{
-		Id:             primitive.NewObjectID(),
+	return entities.AuditLog{
+		Id:             writeID(ctx),
+		Collection:     col,
+		Operation:      ops,
+		DocumentId:     docId,
+		AuditEvent:     ops,
 		AuditURL:       "example.com", // Example, you can replace with real URL
 		AuditIPAddress: getContextValue(ctx, "ip_addr"),
 		AuditUserAgent: getContextValue(ctx, "user_agent"),
@@ -136,33 +253,29 @@ func (h *DefaultHooks) logAuditChanges(ctx context.Context, db *mongo.Mongo, cha
 		AuditCreatedAt: &currentTime,
 		UserID:         getContextValue(ctx, "user_id"),
 		UserType:       getContextValue(ctx, "role"),
-		Change:         changeLog,
-	}
-
-	// Insert the audit log into the collection
-	_, err := db.Database.Collection("audit_logs").InsertOne(context.Background(), auditLog)
-	if err != nil {
-		return fmt.Errorf("error inserting audit log: %w", err)
 	}
-	return nil
 }
 
-// updateAuditLogMeta updates the audit log meta with the new document state.
-func (h *DefaultHooks) updateAuditLogMeta(ctx context.Context, db *mongo.Mongo, auditLogMetaId primitive.ObjectID, newDoc map[string]interface{}) error {
-	update := bson.M{
-		"$set": bson.M{"document_current_state": newDoc},
+// publish forwards written audit logs to every configured sink, a failing sink never affects the others.
+// Audit logs written inside a transaction are held back until it commits.
+func (h *DefaultHooks) publish(ctx context.Context, logs ...entities.AuditLog) {
+	if tx := txFrom(ctx); tx != nil {
+		tx.hold(logs)
+		return
 	}
-
-	_, err := db.Database.Collection("audit_logs_meta").UpdateByID(ctx, auditLogMetaId, update)
-	if err != nil {
-		return fmt.Errorf("error updating audit log meta: %w", err)
+	for _, sink := range h.sinks {
+		if err := sink.Send(ctx, logs); err != nil {
+			h.l.Error(fmt.Sprintf("Failed to send audit logs to sink: %v", err))
+		}
 	}
-	return nil
 }
 
 // isAuditLogEnabled Function to check if the model has audit logging enabled
 func isAuditLogEnabled(model interface{}) bool {
 	modelType := reflect.TypeOf(model)
+	if modelType == nil {
+		return false
+	}
 
 	// If the modelType is a pointer, get the underlying type
 	if modelType.Kind() == reflect.Ptr {
@@ -179,159 +292,44 @@ func isAuditLogEnabled(model interface{}) bool {
 
 	// Get the package path using the types package
 	pkgPath := modelType.PkgPath()
-	return audit.LogModels[pkgPath+typeName]
-}
-
-// hasPreSaveHook Check if the model has a PreSave method (custom user hook)
-func hasPreSaveHook(model interface{}) bool {
-	_, ok := reflect.TypeOf(model).MethodByName("PreSave")
-	return ok
+	return audit.IsRegistered(pkgPath + typeName)
 }
 
-// hasPostSaveHook Check if the model has a PostSave method (custom user hook)
-func hasPostSaveHook(model interface{}) bool {
-	_, ok := reflect.TypeOf(model).MethodByName("PostSave")
-	return ok
-}
-
-// structToMap converts a struct into a map[string]interface{} where the structs
-// field names or associated tags (bson/json) become the keys, and the field values
-// become the corresponding map values. It also handles nested pointers and reflects
-// on the object's structure to ensure it is a struct before processing.
-//
-// Parameters:
-// - obj: An interface{}, expected to be a struct or a pointer to a struct.
-//
-// Returns:
-//   - map[string]interface{}: A map where the keys are the struct field names (or their
-//     bson/json tags) and values are the corresponding struct field values.
-//   - error: If the input is not a struct or a pointer to a struct, an error is returned.
-//
-// The function performs the following steps:
-// 1. Initializes an empty result map to hold the struct field-to-value mapping.
-// 2. Checks if the input is a pointer. If it is, the pointer is dereferenced to access the actual struct.
-// 3. Ensures the input is a struct after dereferencing (or directly). If not, an error is returned.
-// 4. Iterates over each field in the struct using reflection, retrieves field metadata and value.
-// 5. Calls `convertFieldToMapEntry` to handle individual field processing and insertion into the map.
-//
-// Example usage:
-//
-//	type User struct {
-//	    ID   string `json:"id"`
-//	    Name string `json:"name"`
-//	}
-//
-// user := User{ID: "123", Name: "John"}
-// result, err := structToMap(user)
-// If no error, result will be: map["id": "123", "name": "John"]
-func structToMap(obj interface{}) (map[string]interface{}, error) {
-	result := make(map[string]interface{})
-	v := reflect.ValueOf(obj)
-
-	// Check if the input is a pointer and get the element
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+// versionOf returns the version recorded in the audit log of a write of model, nil when it isn't
+// versioned. The version is read from doc, the document the write left, when it holds it: a
+// model passed by value keeps the version it had before the write.
+func versionOf(model interface{}, doc map[string]interface{}) *int64 {
+	field, version, ok := in.VersionOf(model)
+	if !ok {
+		return nil
 	}
-
-	// Ensure the value is a struct
-	if v.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected a struct, got %s", v.Kind())
-	}
-
-	// Iterate over the struct fields
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Type().Field(i)
-		value := v.Field(i)
-		convertFieldToMapEntry(field, value, result)
-	}
-
-	return result, nil
-}
-
-// converts a single struct field to a map entry.
-// It handles ObjectID conversion and respects bson/json tags.
-func convertFieldToMapEntry(field reflect.StructField, value reflect.Value, result map[string]interface{}) {
-	bsonTag := field.Tag.Get("bson")
-	jsonTag := field.Tag.Get("json")
-	// Check if the field should be omitted
-	if strings.Contains(bsonTag, "omitempty") {
-		if isOmitEmpty(value) {
-			return // Skip adding this field if it's empty and has "omitempty"
-		}
-	}
-
-	// Check if the field's value is a MongoDB ObjectID
-	if value.Type() == reflect.TypeOf(primitive.ObjectID{}) {
-		objectID := value.Interface().(primitive.ObjectID)
-
-		// Prioritize bson tag, then json tag, and lastly field name (converted to snake_case)
-		if bsonTag != "" {
-			result[strings.Split(bsonTag, ",")[0]] = objectID.Hex()
-		} else if jsonTag != "" {
-			result[strings.Split(jsonTag, ",")[0]] = objectID.Hex()
-		} else {
-			result[convertToSnakeCase(field.Name)] = objectID.Hex()
-		}
-		return
-	}
-
-	// For non-ObjectID fields, prioritize bson, json, or fallback to snake_case field name
-	if bsonTag != "" {
-		result[strings.Split(bsonTag, ",")[0]] = value.Interface()
-	} else if jsonTag != "" {
-		result[strings.Split(jsonTag, ",")[0]] = value.Interface()
-	} else {
-		result[convertToSnakeCase(field.Name)] = value.Interface()
-	}
-}
-
-// isOmitEmpty checks if a value is considered "empty" according to the omitempty rule
-func isOmitEmpty(value reflect.Value) bool {
-	switch value.Kind() {
-	case reflect.Ptr:
-		return value.IsNil() // Nil pointer is considered empty
-	case reflect.Slice, reflect.Array:
-		return value.Len() == 0 // Empty slice/array is considered empty
-	case reflect.Map:
-		return value.IsNil() || value.Len() == 0 // Nil or empty map is considered empty
-	default:
-		// For all other types, zero value is considered empty
-		zero := reflect.Zero(value.Type())
-		return value.Interface() == zero.Interface()
+	switch v := doc[field].(type) {
+	case int32:
+		version = int64(v)
+	case int64:
+		version = v
+	case int:
+		version = int64(v)
 	}
+	return &version
 }
 
-// convertToSnakeCase converts a CamelCase or PascalCase string to snake_case.
-// It adds underscores before uppercase letters (except the first letter)
-// and converts them to lowercase.
-// Example: "FirstName" becomes "first_name".
-func convertToSnakeCase(str string) string {
-	var snakeCase string
-	runes := []rune(str)
-	length := len(runes)
-
-	for i := 0; i < length; i++ {
-		// Check if the current character is an uppercase letter
-		if unicode.IsUpper(runes[i]) {
-			// Add underscore before the uppercase letter unless it's the first character or the previous character is already an underscore
-			if i > 0 && runes[i-1] != '_' && (unicode.IsLower(runes[i-1]) || (i+1 < length && unicode.IsLower(runes[i+1]))) {
-				snakeCase += "_"
-			}
-			// Convert the uppercase letter to lowercase
-			snakeCase += string(unicode.ToLower(runes[i]))
-		} else {
-			// Just append the lowercase letters and underscores
-			snakeCase += string(runes[i])
-		}
+// formatValue renders a value of a document state, dates read back from the database are
+// rendered like the time.Time they were written from
+func formatValue(v interface{}) string {
+	if d, ok := v.(primitive.DateTime); ok {
+		return fmt.Sprintf("%v", d.Time())
 	}
-	return snakeCase
+	return fmt.Sprintf("%v", v)
 }
 
 // compareDocumentStates compares old and new document states and returns a map of changes.
 // Each change contains the old and new values for fields that were added, modified, or deleted.
 //
-// - Fields with differences between oldDoc and newDoc are recorded as changes.
-// - The _id field is ignored as it is considered immutable.
+//   - Fields with differences between oldDoc and newDoc are recorded as changes. Values are
+//     compared the way they are recorded, so a value read back from the database with another
+//     Go type (e.g. int32 instead of int) is not a change.
+//   - The _id field is ignored as it is considered immutable.
 func compareDocumentStates(oldDoc, newDoc map[string]interface{}) map[string]entities.AuditChange {
 	changes := make(map[string]entities.AuditChange)
 
@@ -341,10 +339,11 @@ func compareDocumentStates(oldDoc, newDoc map[string]interface{}) map[string]ent
 			continue
 		}
 		oldVal, exists := oldDoc[key]
-		if !exists || oldVal != newVal {
+		oldStr, newStr := formatValue(oldVal), formatValue(newVal)
+		if !exists || oldStr != newStr {
 			changes[key] = entities.AuditChange{
-				Old: fmt.Sprintf("%v", oldVal),
-				New: fmt.Sprintf("%v", newVal),
+				Old: oldStr,
+				New: newStr,
 			}
 		}
 	}
@@ -356,7 +355,7 @@ func compareDocumentStates(oldDoc, newDoc map[string]interface{}) map[string]ent
 		}
 		if _, exists := newDoc[key]; !exists {
 			changes[key] = entities.AuditChange{
-				Old: fmt.Sprintf("%v", oldVal),
+				Old: formatValue(oldVal),
 				New: "", // Key was deleted, so no new value
 			}
 		}
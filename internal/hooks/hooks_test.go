@@ -2,13 +2,17 @@ package hooks
 
 import (
 	"context"
+	"errors"
 	in "github.com/its-own/gaudit/in"
 	audit "github.com/its-own/gaudit/internal/audit_log"
 	"github.com/its-own/gaudit/internal/entities"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"reflect"
+	"log/slog"
 	"testing"
+	"time"
 )
 
 // Sample struct for testing
@@ -20,17 +24,12 @@ type TestStruct struct {
 	Address  string             `bson:"address,omitempty"`
 	EmptyVal string             `bson:"empty_val,omitempty"`
 	Unmapped string
+	Seen     time.Time `bson:"seen"`
+	Home     struct {
+		City string `bson:"city"`
+	} `bson:"home"`
 }
 
-// TestStruct with PreSave and PostSave methods
-type TestHookStruct struct{}
-
-func (t *TestHookStruct) PreSave()  {}
-func (t *TestHookStruct) PostSave() {}
-
-// TestStructWithoutHooks without PreSave and PostSave methods
-type TestStructWithoutHooks struct{}
-
 // Mock models for testing
 type TestModelWithAudit struct {
 	in.Inject
@@ -38,35 +37,6 @@ type TestModelWithAudit struct {
 type TestModelWithoutAudit struct{}
 type NonStructModel int
 
-// TestStructWithPreSave is a struct that has a PreSave method.
-type TestStructWithPreSave struct{}
-
-func (t *TestStructWithPreSave) PreSave() {}
-
-// TestStructWithoutPreSave is a struct that does not have a PreSave method.
-type TestStructWithoutPreSave struct{}
-
-// TestHasPreSaveHook tests the hasPreSaveHook function.
-func TestHasPreSaveHook(t *testing.T) {
-	tests := []struct {
-		model     interface{}
-		expectHas bool
-	}{
-		{&TestStructWithPreSave{}, true},     // Should return true
-		{&TestStructWithoutPreSave{}, false}, // Should return false
-		{struct{}{}, false},                  // Anonymous struct without PreSave
-	}
-
-	for _, tt := range tests {
-		t.Run("", func(t *testing.T) {
-			result := hasPreSaveHook(tt.model)
-			if result != tt.expectHas {
-				t.Errorf("expected %v, got %v", tt.expectHas, result)
-			}
-		})
-	}
-}
-
 func TestGetContextValue(t *testing.T) {
 	// Define the key to be used in context
 	const key = "testKey"
@@ -121,7 +91,7 @@ func TestIsAuditLogEnabled(t *testing.T) {
 		{"NonStructModel", NonStructModel(1), false}, // Non-struct input
 		{"EmptyStruct", struct{}{}, false},           // Empty struct
 	}
-	audit.LogModels["github.com/its-own/gaudit/internal/hooksTestModelWithAudit"] = true
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksTestModelWithAudit")
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -133,48 +103,8 @@ func TestIsAuditLogEnabled(t *testing.T) {
 	}
 }
 
-func Test_hasPreSaveHook(t *testing.T) {
-	tests := []struct {
-		name    string
-		model   interface{}
-		hasHook bool
-	}{
-		{"With PreSave", &TestHookStruct{}, true},
-		{"Without PreSave", &TestStructWithoutHooks{}, false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := hasPreSaveHook(tt.model)
-			if got != tt.hasHook {
-				t.Errorf("hasPreSaveHook() = %v, want %v", got, tt.hasHook)
-			}
-		})
-	}
-}
-
-func Test_hasPostSaveHook(t *testing.T) {
-	tests := []struct {
-		name    string
-		model   interface{}
-		hasHook bool
-	}{
-		{"With PostSave", &TestHookStruct{}, true},
-		{"Without PostSave", &TestStructWithoutHooks{}, false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := hasPostSaveHook(tt.model)
-			if got != tt.hasHook {
-				t.Errorf("hasPostSaveHook() = %v, want %v", got, tt.hasHook)
-			}
-		})
-	}
-}
-
-func TestStructToMap(t *testing.T) {
-	// Create test object
+func TestModelToState(t *testing.T) {
+	seen := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.FixedZone("BST", 6*3600))
 	obj := TestStruct{
 		ID:       primitive.NewObjectID(),
 		Name:     "John Doe",
@@ -183,40 +113,26 @@ func TestStructToMap(t *testing.T) {
 		Address:  "123 Main St",
 		EmptyVal: "",
 		Unmapped: "UnmappedField",
+		Seen:     seen,
 	}
+	obj.Home.City = "Dhaka"
 
-	// Call structToMap to convert to a map
-	result, err := structToMap(obj)
-	if err != nil {
-		t.Fatalf("Error converting struct to map: %v", err)
-	}
-
-	// Expected map output
-	expected := map[string]interface{}{
+	result, err := modelToState(obj)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
 		"_id":       obj.ID.Hex(),
 		"name":      "John Doe",
 		"is_active": true,
 		"address":   "123 Main St",
 		"unmapped":  "UnmappedField",
-	}
-
-	// Check that all expected keys exist in the result
-	for key, expectedValue := range expected {
-		value, exists := result[key]
-		if !exists {
-			t.Errorf("Key %s not found in result map", key)
-		} else if value != expectedValue {
-			t.Errorf("Value for key %s mismatch. Expected %v, got %v", key, expectedValue, value)
-		}
-	}
-
-	// Ensure omitted fields are not present
-	omittedKeys := []string{"age", "empty_val"}
-	for _, key := range omittedKeys {
-		if _, exists := result[key]; exists {
-			t.Errorf("Key %s should be omitted but was found in result", key)
-		}
-	}
+		"seen":      primitive.NewDateTimeFromTime(seen),
+		"home":      bson.M{"city": "Dhaka"},
+	}, result, "the state holds the types the driver stores")
+
+	// The zero _id isn't stored, the database generates one
+	result, err = modelToState(TestStruct{})
+	assert.NoError(t, err)
+	assert.NotContains(t, result, "_id")
 }
 
 func Test_compareDocumentStates(t *testing.T) {
@@ -280,6 +196,28 @@ func Test_compareDocumentStates(t *testing.T) {
 				"email": {Old: "test@example.com", New: ""},
 			},
 		},
+		{
+			name: "Same value read back with another type",
+			oldDoc: map[string]interface{}{
+				"age":  int32(30),
+				"tags": []interface{}{"a", "b"},
+			},
+			newDoc: map[string]interface{}{
+				"age":  30,
+				"tags": []string{"a", "b"},
+			},
+			expectedDiff: map[string]entities.AuditChange{},
+		},
+		{
+			name: "Date read back from the database",
+			oldDoc: map[string]interface{}{
+				"seen": primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)),
+			},
+			newDoc: map[string]interface{}{
+				"seen": time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local),
+			},
+			expectedDiff: map[string]entities.AuditChange{},
+		},
 		{
 			name: "Ignore _id field",
 			oldDoc: map[string]interface{}{
@@ -302,115 +240,135 @@ func Test_compareDocumentStates(t *testing.T) {
 	}
 }
 
-func Test_convertToSnakeCase(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		// Test cases for regular camel case
-		{"camelCase", "camel_case"},
-		{"PascalCase", "pascal_case"},
-		{"snake_case", "snake_case"},
-
-		// Test cases for acronyms
-		{"userID", "user_id"},
-		{"HTTPServer", "http_server"},
-		{"MyURL", "my_url"},
-
-		// Test cases for mixed acronyms and camel case
-		{"getUserID", "get_user_id"},
-		{"getHTTPResponse", "get_http_response"},
-
-		// Edge cases
-		{"", ""},
-		{"single", "single"},
-		{"Already_Snake_Case", "already_snake_case"}, // Shouldn't change
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := convertToSnakeCase(tt.input)
-			if result != tt.expected {
-				t.Errorf("expected %s, but got %s", tt.expected, result)
-			}
-		})
-	}
+// captureWriter is a writer keeping the records instead of applying them
+type captureWriter struct {
+	recs []record
 }
 
-func Test_isOmitEmpty(t *testing.T) {
-	// Pointer types
-	var ptr *int
-	if !isOmitEmpty(reflect.ValueOf(ptr)) {
-		t.Errorf("Expected true for nil pointer")
-	}
+func (w *captureWriter) write(_ context.Context, rec record) error {
+	w.recs = append(w.recs, rec)
+	return nil
+}
 
-	ptr = new(int)
-	if isOmitEmpty(reflect.ValueOf(ptr)) {
-		t.Errorf("Expected false for non-nil pointer")
-	}
+func (w *captureWriter) flush(context.Context) error { return nil }
 
-	// Slice types
-	var slice []int
-	if !isOmitEmpty(reflect.ValueOf(slice)) {
-		t.Errorf("Expected true for nil slice")
-	}
+func (w *captureWriter) close(context.Context) error { return nil }
 
-	slice = []int{}
-	if !isOmitEmpty(reflect.ValueOf(slice)) {
-		t.Errorf("Expected true for empty slice")
-	}
+func TestAudit_Delete(t *testing.T) {
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
 
-	slice = []int{1, 2, 3}
-	if isOmitEmpty(reflect.ValueOf(slice)) {
-		t.Errorf("Expected false for non-empty slice")
-	}
+	// Deletes carry no model
+	assert.NoError(t, h.PreSave(context.Background(), nil, nil, "user", "delete", ""))
+	assert.NoError(t, h.PostSave(context.Background(), nil, nil, "user", "delete", "42"))
+	assert.Len(t, w.recs, 1)
+	assert.Equal(t, "delete", w.recs[0].log.Operation)
+	assert.Equal(t, "42", w.recs[0].log.DocumentId)
+	assert.Nil(t, w.recs[0].state)
+	assert.False(t, isAuditLogEnabled(nil))
+}
 
-	// Array types
-	var array [0]int
-	if !isOmitEmpty(reflect.ValueOf(array)) {
-		t.Errorf("Expected true for empty array")
-	}
+// lifecycleModel is an audited model implementing in.Hook and the lifecycle hooks
+type lifecycleModel struct {
+	in.Inject
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Name  string             `bson:"name"`
+	trace *[]string
+	veto  error
+}
 
-	var newArray = [3]int{1, 2, 3}
-	if isOmitEmpty(reflect.ValueOf(newArray)) {
-		t.Errorf("Expected false for non-empty array")
-	}
+func (m *lifecycleModel) PreSave(context.Context, interface{}, interface{}, string, string, string) {
+	*m.trace = append(*m.trace, "PreSave")
+}
 
-	// Map types
-	var m map[string]int
-	if !isOmitEmpty(reflect.ValueOf(m)) {
-		t.Errorf("Expected true for nil map")
-	}
+func (m *lifecycleModel) PostSave(context.Context, interface{}, interface{}, string, string, string) {
+	*m.trace = append(*m.trace, "PostSave")
+}
 
-	m = make(map[string]int)
-	if !isOmitEmpty(reflect.ValueOf(m)) {
-		t.Errorf("Expected true for empty map")
-	}
+func (m *lifecycleModel) BeforeInsert(context.Context) error {
+	*m.trace = append(*m.trace, "BeforeInsert")
+	return m.veto
+}
 
-	m["key"] = 1
-	if isOmitEmpty(reflect.ValueOf(m)) {
-		t.Errorf("Expected false for non-empty map")
-	}
+func (m *lifecycleModel) AfterUpdate(context.Context) error {
+	*m.trace = append(*m.trace, "AfterUpdate")
+	return nil
+}
 
-	// Integer types (default type case)
-	var i int
-	if !isOmitEmpty(reflect.ValueOf(i)) {
-		t.Errorf("Expected true for zero integer")
-	}
+func TestModelHooks_ComposeWithAudit(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hookslifecycleModel")
+	ctx := context.Background()
+
+	t.Run("Audited and hooked", func(t *testing.T) {
+		w := &captureWriter{}
+		h := NewDefaultHook(slog.Default(), nil)
+		h.w = w
+
+		var trace []string
+		model := &lifecycleModel{ID: primitive.NewObjectID(), Name: "John", trace: &trace}
+		assert.NoError(t, h.PreSave(ctx, model, nil, "user", "update", ""))
+		assert.NoError(t, h.PostSave(ctx, model, nil, "user", "update", model.ID.Hex()))
+		assert.Equal(t, []string{"PreSave", "PostSave", "AfterUpdate"}, trace)
+		assert.Len(t, w.recs, 1, "the model's PostSave doesn't replace the audit")
+		assert.Equal(t, "John", w.recs[0].state["name"])
+	})
+
+	t.Run("Value model with pointer receivers", func(t *testing.T) {
+		var trace []string
+		model := lifecycleModel{trace: &trace}
+		h := NewDefaultHook(slog.Default(), nil)
+		h.w = &captureWriter{}
+		assert.NoError(t, h.PreSave(ctx, model, nil, "user", "insert", ""))
+		assert.Equal(t, []string{"PreSave", "BeforeInsert"}, trace)
+	})
+
+	t.Run("Before hook vetoes", func(t *testing.T) {
+		var trace []string
+		denied := errors.New("denied")
+		model := &lifecycleModel{trace: &trace, veto: denied}
+		h := NewDefaultHook(slog.Default(), nil)
+		assert.ErrorIs(t, h.PreSave(ctx, model, nil, "user", "insert", ""), denied)
+	})
+}
 
-	i = 10
-	if isOmitEmpty(reflect.ValueOf(i)) {
-		t.Errorf("Expected false for non-zero integer")
-	}
+// storedModel is an audited model with fields the driver doesn't store as they are
+type storedModel struct {
+	in.Inject
+	ID      string    `bson:"_id"`
+	Born    time.Time `bson:"born"`
+	Address struct {
+		City string `bson:"city"`
+	} `bson:"address"`
+	Bio string `bson:"bio"`
+}
 
-	// String types (default type case)
-	var s string
-	if !isOmitEmpty(reflect.ValueOf(s)) {
-		t.Errorf("Expected true for empty string")
-	}
+// stored returns v as the driver stores and reads it back
+func stored(t *testing.T, v interface{}) bson.M {
+	data, err := bson.Marshal(v)
+	require.NoError(t, err)
+	var doc bson.M
+	require.NoError(t, bson.Unmarshal(data, &doc))
+	return doc
+}
 
-	s = "hello"
-	if isOmitEmpty(reflect.ValueOf(s)) {
-		t.Errorf("Expected false for non-empty string")
-	}
+func TestAudit_UpdateAfterInsertInOneBatch(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksstoredModel")
+	ctx := context.Background()
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
+
+	m := &storedModel{ID: "1", Born: time.Date(1990, 1, 2, 3, 4, 5, 678901234, time.Local), Bio: "hi"}
+	m.Address.City = "Dhaka"
+	require.NoError(t, h.PostSave(ctx, m, nil, "profile", "insert", "1"))
+	before := stored(t, m)
+	m.Bio = "hello"
+	ctx = in.WithImages(ctx, in.Images{Before: before, After: stored(t, m)})
+	require.NoError(t, h.PostSave(ctx, m, nil, "profile", "update", "1"))
+
+	// Both records are planned in one batch, as the async writer does
+	logs, _ := h.plan(w.recs, map[string]*auditLogMetaState{})
+	require.Len(t, logs, 2)
+	assert.Equal(t, map[string]entities.AuditChange{"bio": {Old: "hi", New: "hello"}}, logs[1].Change)
 }
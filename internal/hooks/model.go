@@ -0,0 +1,90 @@
+package hooks
+
+import (
+	"context"
+	in "github.com/its-own/gaudit/in"
+	"reflect"
+)
+
+// modelHookTarget returns the value whose method set holds the hooks of model: model itself
+// when it is a pointer, else a pointer to a copy of it, so hooks with pointer receivers are
+// found on models passed by value too.
+func modelHookTarget(model interface{}) interface{} {
+	if model == nil {
+		return nil
+	}
+	v := reflect.ValueOf(model)
+	if v.Kind() == reflect.Ptr {
+		return model
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface()
+}
+
+// runModelPreSave runs the PreSave and Before<Operation> hooks implemented by model
+func runModelPreSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	target := modelHookTarget(model)
+	switch hook := target.(type) {
+	case in.HookV2:
+		if err := hook.PreSave(ctx, model, filter, col, ops, docId); err != nil {
+			return err
+		}
+	case in.Hook:
+		hook.PreSave(ctx, model, filter, col, ops, docId)
+	}
+
+	switch ops {
+	case "insert":
+		if hook, ok := target.(in.BeforeInsert); ok {
+			return hook.BeforeInsert(ctx)
+		}
+	case "update":
+		if hook, ok := target.(in.BeforeUpdate); ok {
+			return hook.BeforeUpdate(ctx)
+		}
+	case "delete":
+		if hook, ok := target.(in.BeforeDelete); ok {
+			return hook.BeforeDelete(ctx)
+		}
+	}
+	return nil
+}
+
+// runModelPostSave runs the PostSave and After<Operation> hooks implemented by model
+func runModelPostSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	target := modelHookTarget(model)
+	switch hook := target.(type) {
+	case in.HookV2:
+		if err := hook.PostSave(ctx, model, filter, col, ops, docId); err != nil {
+			return err
+		}
+	case in.Hook:
+		hook.PostSave(ctx, model, filter, col, ops, docId)
+	}
+
+	switch ops {
+	case "insert":
+		if hook, ok := target.(in.AfterInsert); ok {
+			return hook.AfterInsert(ctx)
+		}
+	case "update":
+		if hook, ok := target.(in.AfterUpdate); ok {
+			return hook.AfterUpdate(ctx)
+		}
+	case "delete":
+		if hook, ok := target.(in.AfterDelete); ok {
+			return hook.AfterDelete(ctx)
+		}
+	case "upsert", "replace":
+		// The write turned out to be an insert when the document had no before image
+		if images, _ := in.ImagesFrom(ctx); images.Before == nil {
+			if hook, ok := target.(in.AfterInsert); ok {
+				return hook.AfterInsert(ctx)
+			}
+		} else if hook, ok := target.(in.AfterUpdate); ok {
+			return hook.AfterUpdate(ctx)
+		}
+	}
+	return nil
+}
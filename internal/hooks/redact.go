@@ -0,0 +1,35 @@
+package hooks
+
+// Redacted replaces the value of redacted fields in audit logs and document states
+const Redacted = "[REDACTED]"
+
+// WithRedaction masks the given fields of the documents of each collection, keyed by collection
+// name, "*" applying to every collection. Field names are the stored (bson) names of top-level
+// fields. A redacted value never reaches the audit trail, so its changes aren't recorded either.
+func (h *DefaultHooks) WithRedaction(fields map[string][]string) *DefaultHooks {
+	h.redact = make(map[string]map[string]bool, len(fields))
+	for col, names := range fields {
+		h.redact[col] = toSet(names)
+	}
+	return h
+}
+
+// redactState masks the redacted fields of a document state of col in place
+func (h *DefaultHooks) redactState(col string, state map[string]interface{}) {
+	if len(h.redact) == 0 || state == nil {
+		return
+	}
+	for field := range state {
+		if h.redact["*"][field] || h.redact[col][field] {
+			state[field] = Redacted
+		}
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,106 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"sort"
+	"time"
+)
+
+// RetentionOptions configures how long audit logs are kept, zero values fall back to defaults
+type RetentionOptions struct {
+	// Policies is the retention of the audit logs of each collection, e.g. 90 days for "session",
+	// 0 keeps them forever
+	Policies map[string]time.Duration
+	// Default is the retention of the collections without a policy, 0 keeps their audit logs forever
+	Default time.Duration
+	// Interval is the wait between two purges, 1h by default
+	Interval time.Duration
+	// LegalHold exempts audit logs from purges, whatever their age
+	LegalHold LegalHold
+}
+
+// LegalHold lists the audit logs that must never be purged
+type LegalHold struct {
+	// Documents are the ids of the documents whose audit logs are held, see in.DocumentID
+	Documents []string
+	// Users are the ids of the users whose audit logs are held
+	Users []string
+}
+
+// Exclude adds the conditions leaving the held audit logs out to filter and returns it
+func (l LegalHold) Exclude(filter bson.M) bson.M {
+	if len(l.Documents) > 0 {
+		filter["document_id"] = bson.M{"$nin": l.Documents}
+	}
+	if len(l.Users) > 0 {
+		filter["user_id"] = bson.M{"$nin": l.Users}
+	}
+	return filter
+}
+
+// Retain purges the audit logs past their retention every opts.Interval until the hook is closed
+func (h *DefaultHooks) Retain(opts RetentionOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	h.background(func(ctx context.Context) {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			deleted, err := h.Purge(ctx, opts)
+			if err != nil && ctx.Err() == nil {
+				h.l.Error(fmt.Sprintf("Failed to purge audit logs: %v", err))
+			}
+			if deleted > 0 {
+				h.l.Info(fmt.Sprintf("Purged %d audit logs", deleted))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// Purge deletes the audit logs past their retention that aren't on legal hold and returns their count
+func (h *DefaultHooks) Purge(ctx context.Context, opts RetentionOptions) (int64, error) {
+	var deleted int64
+	for _, filter := range purgeFilters(opts, time.Now()) {
+		res, err := h.logs().DeleteMany(ctx, filter)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+	}
+	return deleted, nil
+}
+
+// purgeFilters returns a filter per retention policy matching the audit logs to purge at now
+func purgeFilters(opts RetentionOptions, now time.Time) []bson.M {
+	var filters []bson.M
+	collections := make([]string, 0, len(opts.Policies))
+	for col := range opts.Policies {
+		collections = append(collections, col)
+	}
+	sort.Strings(collections)
+	for _, col := range collections {
+		retention := opts.Policies[col]
+		if retention <= 0 {
+			continue
+		}
+		filters = append(filters, opts.LegalHold.Exclude(bson.M{
+			"collection":       col,
+			"audit_created_at": bson.M{"$lt": now.Add(-retention)},
+		}))
+	}
+	if opts.Default > 0 {
+		filters = append(filters, opts.LegalHold.Exclude(bson.M{
+			"collection":       bson.M{"$nin": collections},
+			"audit_created_at": bson.M{"$lt": now.Add(-opts.Default)},
+		}))
+	}
+	return filters
+}
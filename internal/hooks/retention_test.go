@@ -0,0 +1,48 @@
+package hooks
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func Test_purgeFilters(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		opts RetentionOptions
+		want []bson.M
+	}{
+		{"Kept forever by default", RetentionOptions{}, nil},
+		{
+			"Per collection policies",
+			RetentionOptions{Policies: map[string]time.Duration{"session": 90 * day, "ledger": 0}},
+			[]bson.M{{"collection": "session", "audit_created_at": bson.M{"$lt": now.Add(-90 * day)}}},
+		},
+		{
+			"Default policy skips the collections with a policy",
+			RetentionOptions{Policies: map[string]time.Duration{"ledger": 0, "session": 90 * day}, Default: 365 * day},
+			[]bson.M{
+				{"collection": "session", "audit_created_at": bson.M{"$lt": now.Add(-90 * day)}},
+				{"collection": bson.M{"$nin": []string{"ledger", "session"}}, "audit_created_at": bson.M{"$lt": now.Add(-365 * day)}},
+			},
+		},
+		{
+			"Legal hold",
+			RetentionOptions{Default: day, LegalHold: LegalHold{Documents: []string{"42"}, Users: []string{"auditor"}}},
+			[]bson.M{{
+				"collection":       bson.M{"$nin": []string{}},
+				"audit_created_at": bson.M{"$lt": now.Add(-day)},
+				"document_id":      bson.M{"$nin": []string{"42"}},
+				"user_id":          bson.M{"$nin": []string{"auditor"}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, purgeFilters(tt.opts, now))
+		})
+	}
+}
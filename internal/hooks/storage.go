@@ -0,0 +1,161 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/internal/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditStore persists the audit trail, the audit collections of StorageOptions by default
+type AuditStore interface {
+	// FindMetas returns the metas tracking the documents docIds
+	FindMetas(ctx context.Context, docIds []string) ([]entities.AuditLogMeta, error)
+	// WriteMetas applies writes to the metas, in order
+	WriteMetas(ctx context.Context, writes []driver.WriteModel) error
+	InsertLogs(ctx context.Context, logs []entities.AuditLog) error
+}
+
+// StorageOptions configures where the audit trail is stored, zero values fall back to defaults
+type StorageOptions struct {
+	// Database holding the audit collections, the audited database by default
+	Database *driver.Database
+	// LogCollection holds the audit logs, "audit_logs" by default
+	LogCollection string
+	// MetaCollection holds the last known state of every audited document, "audit_logs_meta" by default
+	MetaCollection string
+	// LogIndexes are ensured on LogCollection, DefaultLogIndexes when nil
+	LogIndexes []db.Index
+	// MetaIndexes are ensured on MetaCollection, DefaultMetaIndexes when nil
+	MetaIndexes []db.Index
+}
+
+// DefaultLogIndexes serve the history of a document, the lookup of the logs of a meta and
+// the retention purges
+var DefaultLogIndexes = []db.Index{
+	{
+		Name: "collection_document_id_audit_created_at",
+		Keys: []db.IndexKey{{Key: "collection", Asc: 1}, {Key: "document_id", Asc: 1}, {Key: "audit_created_at", Asc: 1}},
+	},
+	{
+		Name: "audit_meta_id",
+		Keys: []db.IndexKey{{Key: "audit_meta_id", Asc: 1}},
+	},
+	{
+		Name: "collection_audit_created_at",
+		Keys: []db.IndexKey{{Key: "collection", Asc: 1}, {Key: "audit_created_at", Asc: 1}},
+	},
+}
+
+// DefaultMetaIndexes serve the lookup of the metas of the written documents
+var DefaultMetaIndexes = []db.Index{
+	{
+		Name: "document_current_state_id",
+		Keys: []db.IndexKey{{Key: "document_current_state._id", Asc: 1}},
+	},
+}
+
+// withDefaults returns opts with the zero values replaced by defaults, auditDb is the audited database
+func (opts StorageOptions) withDefaults(auditDb *driver.Database) StorageOptions {
+	if opts.Database == nil {
+		opts.Database = auditDb
+	}
+	if opts.LogCollection == "" {
+		opts.LogCollection = "audit_logs"
+	}
+	if opts.MetaCollection == "" {
+		opts.MetaCollection = "audit_logs_meta"
+	}
+	if opts.LogIndexes == nil {
+		opts.LogIndexes = DefaultLogIndexes
+	}
+	if opts.MetaIndexes == nil {
+		opts.MetaIndexes = DefaultMetaIndexes
+	}
+	return opts
+}
+
+// WithStorage sets where the audit trail is stored
+func (h *DefaultHooks) WithStorage(opts StorageOptions) *DefaultHooks {
+	h.storage = opts.withDefaults(h.db)
+	if h.storage.Database != nil {
+		h.store = mongoStore{logs: h.logs(), metas: h.metas()}
+	}
+	return h
+}
+
+// WithStore replaces the audit collections by store, e.g. an in-memory store in unit tests
+func (h *DefaultHooks) WithStore(store AuditStore) *DefaultHooks {
+	h.store = store
+	return h
+}
+
+// Storage returns where the audit trail is stored, defaults included
+func (h *DefaultHooks) Storage() StorageOptions {
+	return h.storage
+}
+
+func (h *DefaultHooks) logs() *driver.Collection {
+	return h.storage.Database.Collection(h.storage.LogCollection)
+}
+
+func (h *DefaultHooks) metas() *driver.Collection {
+	return h.storage.Database.Collection(h.storage.MetaCollection)
+}
+
+// mongoStore is the AuditStore of the audit collections
+type mongoStore struct {
+	logs  *driver.Collection
+	metas *driver.Collection
+}
+
+func (s mongoStore) FindMetas(ctx context.Context, docIds []string) ([]entities.AuditLogMeta, error) {
+	filter := bson.D{{Key: "document_current_state._id", Value: bson.M{"$in": docIds}}}
+	cursor, err := s.metas.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error finding audit log meta: %w", err)
+	}
+	var metas []entities.AuditLogMeta
+	if err := cursor.All(ctx, &metas); err != nil {
+		return nil, fmt.Errorf("error finding audit log meta: %w", err)
+	}
+	return metas, nil
+}
+
+func (s mongoStore) WriteMetas(ctx context.Context, writes []driver.WriteModel) error {
+	if _, err := s.metas.BulkWrite(ctx, writes); err != nil {
+		return fmt.Errorf("error writing audit log meta: %w", err)
+	}
+	return nil
+}
+
+func (s mongoStore) InsertLogs(ctx context.Context, logs []entities.AuditLog) error {
+	docs := make([]interface{}, 0, len(logs))
+	for _, log := range logs {
+		docs = append(docs, log)
+	}
+	// Logs inserted by an earlier attempt of the same write are skipped
+	_, err := s.logs.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicates(err) {
+		return fmt.Errorf("error inserting audit log: %w", err)
+	}
+	return nil
+}
+
+// onlyDuplicates reports whether every write error of err is a duplicate key error
+func onlyDuplicates(err error) bool {
+	var bulk driver.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil || len(bulk.WriteErrors) == 0 {
+		return false
+	}
+	for _, e := range bulk.WriteErrors {
+		if e.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/its-own/gaudit/internal/infracture/db/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestWithStorage(t *testing.T) {
+	// Connect doesn't reach the server, it is enough to build databases
+	client, err := driver.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	assert.NoError(t, err)
+	audited, other := client.Database("app"), client.Database("audit")
+
+	h := NewDefaultHook(slog.Default(), audited)
+	assert.Equal(t, "app", h.Storage().Database.Name())
+	assert.Equal(t, "audit_logs", h.logs().Name())
+	assert.Equal(t, "audit_logs_meta", h.metas().Name())
+	assert.Equal(t, DefaultMetaIndexes, h.Storage().MetaIndexes)
+
+	h.WithStorage(StorageOptions{Database: other, LogCollection: "trail", MetaIndexes: []db.Index{}})
+	assert.Equal(t, "audit", h.logs().Database().Name())
+	assert.Equal(t, "trail", h.logs().Name())
+	assert.Equal(t, "audit_logs_meta", h.metas().Name())
+	assert.Equal(t, DefaultLogIndexes, h.Storage().LogIndexes)
+	assert.Empty(t, h.Storage().MetaIndexes, "an empty index list disables the default")
+}
+
+// flakyStore is a memory AuditStore whose first writes fail
+type flakyStore struct {
+	*memory.AuditStore
+	conn         *memory.Memory
+	logFailures  int
+	metaFailures int
+}
+
+func newFlakyStore() *flakyStore {
+	conn := memory.InitMemory(nil)
+	return &flakyStore{AuditStore: memory.NewAuditStore(conn, "audit_logs", "audit_logs_meta"), conn: conn}
+}
+
+func (s *flakyStore) InsertLogs(ctx context.Context, logs []entities.AuditLog) error {
+	if s.logFailures > 0 {
+		s.logFailures--
+		return errors.New("logs unavailable")
+	}
+	return s.AuditStore.InsertLogs(ctx, logs)
+}
+
+func (s *flakyStore) WriteMetas(ctx context.Context, writes []driver.WriteModel) error {
+	if s.metaFailures > 0 {
+		s.metaFailures--
+		return errors.New("metas unavailable")
+	}
+	return s.AuditStore.WriteMetas(ctx, writes)
+}
+
+func (s *flakyStore) count(t *testing.T, col string) int64 {
+	n, err := s.conn.Count(context.Background(), col, bson.M{})
+	require.NoError(t, err)
+	return n
+}
+
+func TestApply_LogsBeforeMetas(t *testing.T) {
+	store := newFlakyStore()
+	h := NewDefaultHook(slog.Default(), nil).WithStore(store)
+	ctx := context.Background()
+	require.NoError(t, h.apply(ctx, []record{planRecord("insert", "1", map[string]interface{}{"_id": "1", "name": "a"})}))
+
+	// A failed log insert leaves the meta at the last logged state
+	store.logFailures = 1
+	assert.Error(t, h.apply(ctx, []record{planRecord("update", "1", map[string]interface{}{"_id": "1", "name": "b"})}))
+	metas, err := store.FindMetas(ctx, []string{"1"})
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, "a", metas[0].DocumentCurrentState["name"])
+	assert.Equal(t, int64(1), store.count(t, "audit_logs"))
+}
+
+func TestApply_RetriedAudit(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksstoredModel")
+	store := newFlakyStore()
+	h := NewDefaultHook(slog.Default(), nil).WithStore(store)
+	chain := NewChain(h).WithFailurePolicy(FailurePolicy{Mode: RetryFailure, Backoff: time.Millisecond})
+	ctx := context.Background()
+
+	// The meta write of the insert fails once, the retry doesn't create a second meta
+	store.metaFailures = 1
+	m := &storedModel{ID: "1", Bio: "hi"}
+	require.NoError(t, chain.PostSave(ctx, m, nil, "profile", "insert", "1"))
+	assert.Equal(t, int64(1), store.count(t, "audit_logs"))
+	assert.Equal(t, int64(1), store.count(t, "audit_logs_meta"))
+
+	// The retried update logs its change once, against the state before the update
+	store.metaFailures = 1
+	before := stored(t, m)
+	m.Bio = "hello"
+	ctx = in.WithImages(ctx, in.Images{Before: before, After: stored(t, m)})
+	require.NoError(t, chain.PostSave(ctx, m, nil, "profile", "update", "1"))
+	var logs []entities.AuditLog
+	require.NoError(t, store.conn.List(ctx, "audit_logs", bson.M{"operation": "update"}, 0, 0, &logs))
+	require.Len(t, logs, 1)
+	assert.Equal(t, map[string]entities.AuditChange{"bio": {Old: "hi", New: "hello"}}, logs[0].Change)
+}
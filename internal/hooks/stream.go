@@ -0,0 +1,227 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	in "github.com/its-own/gaudit/in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ChangeStreamOptions configures the auditing of writes that don't go through gaudit,
+// e.g. made by other services, migrations or the mongo shell
+type ChangeStreamOptions struct {
+	// Collections to watch
+	Collections []string
+	// TokenCollection stores the resume token of every watched collection in the audit database,
+	// "audit_resume_tokens" by default
+	TokenCollection string
+	// RetryInterval is the wait before a failed change stream is reopened, 5s by default
+	RetryInterval time.Duration
+	// Delay is how long after a write its change event is audited, 2s by default. It leaves the
+	// hooks time to audit the writes made through gaudit, Async writers of other processes
+	// included, so the change stream recognises them instead of logging them first.
+	Delay time.Duration
+}
+
+// resumeToken is the position of a collection's change stream, saved after each audited event
+type resumeToken struct {
+	Collection string    `bson:"_id"`
+	Token      bson.Raw  `bson:"token"`
+	UpdatedAt  time.Time `bson:"updated_at"`
+}
+
+// changeEvent holds the fields of a change stream event used for auditing
+type changeEvent struct {
+	OperationType            string              `bson:"operationType"`
+	DocumentKey              bson.M              `bson:"documentKey"`
+	FullDocument             bson.M              `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.M              `bson:"fullDocumentBeforeChange"`
+	ClusterTime              primitive.Timestamp `bson:"clusterTime"`
+	Ns                       struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+}
+
+// Watch audits the writes to the given collections through change streams until the hook is closed.
+// The collections need changeStreamPreAndPostImages enabled: updates are audited from their
+// post-image, and pre-images are the baseline of untracked documents. Writes already audited by
+// the hook are recognised by the cluster time stored with their audit, see in.WriteTime, and
+// writes made in a transaction by the document state they left, which matches the tracked state.
+func (h *DefaultHooks) Watch(opts ChangeStreamOptions) {
+	if opts.TokenCollection == "" {
+		opts.TokenCollection = "audit_resume_tokens"
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 5 * time.Second
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = 2 * time.Second
+	}
+	for _, col := range opts.Collections {
+		h.background(func(ctx context.Context) {
+			h.watch(ctx, col, opts)
+		})
+	}
+}
+
+// watch keeps a change stream open on col, reopening it from the last saved resume token on failure
+func (h *DefaultHooks) watch(ctx context.Context, col string, opts ChangeStreamOptions) {
+	for {
+		err := h.stream(ctx, col, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		h.l.Error(fmt.Sprintf("Change stream of %s failed: %v", col, err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.RetryInterval):
+		}
+	}
+}
+
+func (h *DefaultHooks) stream(ctx context.Context, col string, opts ChangeStreamOptions) error {
+	tokens := h.storage.Database.Collection(opts.TokenCollection)
+
+	csOpts := options.ChangeStream().
+		SetFullDocument(options.WhenAvailable).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	var saved resumeToken
+	err := tokens.FindOne(ctx, bson.M{"_id": col}).Decode(&saved)
+	switch {
+	case err == nil:
+		csOpts.SetResumeAfter(saved.Token)
+	case !errors.Is(err, driver.ErrNoDocuments):
+		return fmt.Errorf("error finding resume token: %w", err)
+	}
+
+	pipeline := driver.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}},
+	}}}}
+	cs, err := h.db.Collection(col).Watch(ctx, pipeline, csOpts)
+	if err != nil {
+		return err
+	}
+	defer cs.Close(context.Background())
+
+	for cs.Next(ctx) {
+		var event changeEvent
+		if err := cs.Decode(&event); err != nil {
+			return err
+		}
+		// The token is saved once the event is audited, so a crash replays it rather than losing it
+		if err := h.auditEvent(ctx, event, opts.Delay); err != nil {
+			return err
+		}
+		_, err := tokens.UpdateOne(ctx, bson.M{"_id": col},
+			bson.M{"$set": bson.M{"token": cs.ResumeToken(), "updated_at": time.Now()}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("error saving resume token: %w", err)
+		}
+	}
+	return cs.Err()
+}
+
+// auditEvent audits event once it is delay old. The records the hook holds by then are applied
+// first, so a write audited by the hook is recognised even with an Async writer.
+func (h *DefaultHooks) auditEvent(ctx context.Context, event changeEvent, delay time.Duration) error {
+	rec, ok := eventToRecord(event)
+	if !ok {
+		if event.OperationType == "update" && event.FullDocument == nil {
+			h.l.Warn(fmt.Sprintf("Update of %v in %s has no post-image, enable changeStreamPreAndPostImages on the collection",
+				event.DocumentKey["_id"], event.Ns.Coll))
+		}
+		return nil
+	}
+	// Cluster times count seconds, the wait may be up to a second short
+	if wait := delay - time.Since(time.Unix(int64(event.ClusterTime.T), 0)); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	if err := h.w.flush(ctx); err != nil {
+		return err
+	}
+	return h.apply(ctx, []record{rec})
+}
+
+// eventToRecord converts a change event into an audit record, it reports false for
+// events that can't be audited
+func eventToRecord(event changeEvent) (record, bool) {
+	id, ok := event.DocumentKey["_id"]
+	if !ok {
+		return record{}, false
+	}
+	var ops string
+	switch event.OperationType {
+	case "insert":
+		ops = "insert"
+	case "update", "replace":
+		ops = "update"
+		// The post-image is missing, the collection doesn't record them or they expired
+		if event.FullDocument == nil {
+			return record{}, false
+		}
+	case "delete":
+		ops = "delete"
+	default:
+		return record{}, false
+	}
+
+	log := newAuditLog(context.Background(), event.Ns.Coll, ops, in.DocumentID(id))
+	log.AuditTags = append(log.AuditTags, "change_stream")
+	written := in.WriteTime{From: event.ClusterTime, To: event.ClusterTime}
+	if !written.To.IsZero() {
+		log.ClusterTime = &written.To
+	}
+	return record{
+		log:     log,
+		state:   documentToState(event.FullDocument),
+		before:  documentToState(event.FullDocumentBeforeChange),
+		dedupe:  true,
+		written: written,
+	}, true
+}
+
+// modelToState converts a model into the document state of the document the driver stores
+// for it, so it compares with the stored documents of images and change streams
+func modelToState(model interface{}) (map[string]interface{}, error) {
+	data, err := bson.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return documentToState(doc), nil
+}
+
+// documentToState converts a stored document into a document state, top level ObjectIDs are
+// stored as hex strings and the _id in its canonical form
+func documentToState(doc bson.M) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	state := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		if key == "_id" {
+			state[key] = in.DocumentID(value)
+			continue
+		}
+		if id, ok := value.(primitive.ObjectID); ok {
+			state[key] = id.Hex()
+			continue
+		}
+		state[key] = value
+	}
+	return state
+}
@@ -0,0 +1,172 @@
+package hooks
+
+import (
+	"context"
+	"github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestEventToRecord(t *testing.T) {
+	id := primitive.NewObjectID()
+	event := changeEvent{
+		OperationType:            "replace",
+		DocumentKey:              bson.M{"_id": id},
+		FullDocument:             bson.M{"_id": id, "name": "b"},
+		FullDocumentBeforeChange: bson.M{"_id": id, "name": "a"},
+	}
+	event.Ns.Coll = "user"
+
+	rec, ok := eventToRecord(event)
+	assert.True(t, ok)
+	assert.True(t, rec.dedupe)
+	assert.Equal(t, "update", rec.log.Operation)
+	assert.Equal(t, "user", rec.log.Collection)
+	assert.Equal(t, id.Hex(), rec.log.DocumentId)
+	assert.Contains(t, rec.log.AuditTags, "change_stream")
+	assert.Equal(t, map[string]interface{}{"_id": id.Hex(), "name": "b"}, rec.state)
+	assert.Equal(t, map[string]interface{}{"_id": id.Hex(), "name": "a"}, rec.before)
+}
+
+func TestEventToRecord_NonObjectIDKey(t *testing.T) {
+	event := changeEvent{
+		OperationType: "insert",
+		DocumentKey:   bson.M{"_id": int64(42)},
+		FullDocument:  bson.M{"_id": int64(42), "name": "a"},
+	}
+	rec, ok := eventToRecord(event)
+	assert.True(t, ok)
+	assert.Equal(t, "42", rec.log.DocumentId)
+	assert.Equal(t, "42", rec.state["_id"])
+}
+
+func TestEventToRecord_Skipped(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name  string
+		event changeEvent
+	}{
+		{"Unsupported operation", changeEvent{OperationType: "drop", DocumentKey: bson.M{"_id": id}}},
+		{"Update of a deleted document", changeEvent{OperationType: "update", DocumentKey: bson.M{"_id": id}}},
+		{"Missing key", changeEvent{OperationType: "insert", DocumentKey: bson.M{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := eventToRecord(tt.event)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestDocumentToState(t *testing.T) {
+	id := primitive.NewObjectID()
+	assert.Nil(t, documentToState(nil))
+	assert.Equal(t, map[string]interface{}{"_id": id.Hex(), "age": int32(3)}, documentToState(bson.M{"_id": id, "age": int32(3)}))
+}
+
+func TestEventToRecord_DedupesHookWrites(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksstoredModel")
+	ctx := context.Background()
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
+
+	m := &storedModel{ID: "1", Born: time.Date(1990, 1, 2, 3, 4, 5, 678901234, time.Local), Bio: "hi"}
+	m.Address.City = "Dhaka"
+	require.NoError(t, h.PostSave(ctx, m, nil, "profile", "insert", "1"))
+	insert, ok := eventToRecord(changeEvent{OperationType: "insert", DocumentKey: bson.M{"_id": "1"}, FullDocument: stored(t, m)})
+	require.True(t, ok)
+
+	// A partial update without images is merged into the tracked state
+	m.Born = m.Born.Add(time.Hour)
+	m.Bio = "hello"
+	require.NoError(t, h.PostSave(ctx, bson.M{"born": m.Born, "bio": m.Bio}, nil, "profile", "update", "1"))
+	update, ok := eventToRecord(changeEvent{OperationType: "update", DocumentKey: bson.M{"_id": "1"}, FullDocument: stored(t, m)})
+	require.True(t, ok)
+
+	// The stream sees the stored documents of the writes the hooks already audited
+	logs, _ := h.plan([]record{w.recs[0], insert, w.recs[1], update}, map[string]*auditLogMetaState{})
+	require.Len(t, logs, 2)
+	assert.NotContains(t, logs[0].AuditTags, "change_stream")
+	assert.NotContains(t, logs[1].AuditTags, "change_stream")
+	assert.ElementsMatch(t, []string{"born", "bio"}, keys(logs[1].Change))
+}
+
+func TestAuditEvent_AsyncHookWrites(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksstoredModel")
+	store := newFlakyStore()
+	h := NewAsyncHook(slog.Default(), nil, AsyncOptions{FlushInterval: time.Hour}).WithStore(store)
+	defer h.Close(context.Background())
+	ctx := context.Background()
+	at := func(i uint32) primitive.Timestamp { return primitive.Timestamp{T: uint32(time.Now().Unix()), I: i} }
+	event := func(ops string, doc bson.M, i uint32) changeEvent {
+		e := changeEvent{OperationType: ops, DocumentKey: bson.M{"_id": "1"}, FullDocument: doc, ClusterTime: at(i)}
+		e.Ns.Coll = "profile"
+		return e
+	}
+
+	// The hook writes are queued when their change events arrive
+	m := &storedModel{ID: "1", Bio: "hi"}
+	insertCtx := in.WithWriteTime(ctx, in.WriteTime{From: at(1), To: at(1)})
+	require.NoError(t, h.PostSave(insertCtx, m, nil, "profile", "insert", "1"))
+	inserted := stored(t, m)
+	m.Bio = "hello"
+	updateCtx := in.WithImages(ctx, in.Images{Before: inserted, After: stored(t, m)})
+	updateCtx = in.WithWriteTime(updateCtx, in.WriteTime{From: at(2), To: at(2)})
+	require.NoError(t, h.PostSave(updateCtx, m, nil, "profile", "update", "1"))
+	require.NoError(t, h.auditEvent(ctx, event("insert", inserted, 1), 0))
+	require.NoError(t, h.auditEvent(ctx, event("update", stored(t, m), 2), 0))
+
+	// A write that bypassed the hook is audited by the change stream
+	m.Bio = "bye"
+	require.NoError(t, h.auditEvent(ctx, event("update", stored(t, m), 3), 0))
+
+	var logs []entities.AuditLog
+	require.NoError(t, store.conn.List(ctx, "audit_logs", bson.M{}, 0, 0, &logs, bson.M{"_id": 1}))
+	require.Len(t, logs, 3)
+	assert.NotContains(t, logs[0].AuditTags, "change_stream")
+	assert.NotContains(t, logs[1].AuditTags, "change_stream")
+	assert.Equal(t, map[string]entities.AuditChange{"bio": {Old: "hi", New: "hello"}}, logs[1].Change)
+	assert.Contains(t, logs[2].AuditTags, "change_stream")
+	assert.Equal(t, map[string]entities.AuditChange{"bio": {Old: "hello", New: "bye"}}, logs[2].Change)
+	assert.Equal(t, at(3), *logs[2].ClusterTime)
+}
+
+func TestPlan_StreamBeforeHook(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/hooksstoredModel")
+	ctx := context.Background()
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
+	written := in.WriteTime{From: primitive.Timestamp{T: 10, I: 1}, To: primitive.Timestamp{T: 10, I: 1}}
+
+	m := &storedModel{ID: "1", Bio: "hi"}
+	require.NoError(t, h.PostSave(in.WithWriteTime(ctx, written), m, nil, "profile", "insert", "1"))
+	// The stream's document differs from the hook's state, the write is recognised by its cluster time
+	doc := stored(t, m)
+	doc["extra"] = "set by a default"
+	event := changeEvent{OperationType: "insert", DocumentKey: bson.M{"_id": "1"}, FullDocument: doc, ClusterTime: written.To}
+	event.Ns.Coll = "profile"
+	insert, ok := eventToRecord(event)
+	require.True(t, ok)
+
+	logs, metas := h.plan([]record{insert, w.recs[0]}, map[string]*auditLogMetaState{})
+	require.Len(t, logs, 1, "the hook record of a write the stream audited is skipped")
+	assert.Contains(t, logs[0].AuditTags, "change_stream")
+	require.Len(t, metas, 1)
+}
+
+func keys(m map[string]entities.AuditChange) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package hooks
+
+import (
+	"context"
+	"github.com/its-own/gaudit/internal/entities"
+	"sync"
+)
+
+type txKey struct{}
+
+// txState holds the audit logs written inside a transaction until it commits
+type txState struct {
+	mu   sync.Mutex
+	logs []entities.AuditLog
+}
+
+func (t *txState) hold(logs []entities.AuditLog) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.logs = append(t.logs, logs...)
+}
+
+func txFrom(ctx context.Context) *txState {
+	tx, _ := ctx.Value(txKey{}).(*txState)
+	return tx
+}
+
+func inTx(ctx context.Context) bool {
+	return txFrom(ctx) != nil
+}
+
+// BeginTx marks ctx as running inside a transaction: audit logs are written
+// synchronously, with ctx, and are only published to the sinks by CommitTx.
+// It must be called on every attempt of a retried transaction.
+func (h *DefaultHooks) BeginTx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, txKey{}, &txState{})
+}
+
+// CommitTx publishes the audit logs held by a committed transaction started with BeginTx
+func (h *DefaultHooks) CommitTx(ctx context.Context) {
+	tx := txFrom(ctx)
+	if tx == nil {
+		return
+	}
+	tx.mu.Lock()
+	logs := tx.logs
+	tx.logs = nil
+	tx.mu.Unlock()
+	if len(logs) > 0 {
+		h.publish(context.WithValue(ctx, txKey{}, nil), logs...)
+	}
+}
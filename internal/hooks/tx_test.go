@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"context"
+	in "github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+// sinkRecorder is an in.Sink keeping every audit log it receives
+type sinkRecorder struct {
+	logs []in.AuditLog
+}
+
+func (s *sinkRecorder) Send(_ context.Context, logs []in.AuditLog) error {
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func TestPublish_HeldUntilCommit(t *testing.T) {
+	sink := &sinkRecorder{}
+	h := NewDefaultHook(slog.Default(), nil, sink)
+
+	ctx := h.BeginTx(context.Background())
+	h.publish(ctx, entities.AuditLog{DocumentId: "1"})
+	h.publish(ctx, entities.AuditLog{DocumentId: "2"})
+	assert.Empty(t, sink.logs, "nothing is published before the transaction commits")
+
+	h.CommitTx(ctx)
+	assert.Len(t, sink.logs, 2)
+
+	// Committing twice doesn't publish again
+	h.CommitTx(ctx)
+	assert.Len(t, sink.logs, 2)
+}
+
+func TestPublish_DiscardedOnAbort(t *testing.T) {
+	sink := &sinkRecorder{}
+	h := NewDefaultHook(slog.Default(), nil, sink)
+
+	// An aborted attempt is simply never committed, the retry starts with a fresh context
+	aborted := h.BeginTx(context.Background())
+	h.publish(aborted, entities.AuditLog{DocumentId: "1"})
+
+	retried := h.BeginTx(context.Background())
+	h.publish(retried, entities.AuditLog{DocumentId: "1"})
+	h.CommitTx(retried)
+	assert.Len(t, sink.logs, 1)
+}
+
+func TestPublish_OutsideTransaction(t *testing.T) {
+	sink := &sinkRecorder{}
+	h := NewDefaultHook(slog.Default(), nil, sink)
+
+	h.publish(context.Background(), entities.AuditLog{DocumentId: "1"})
+	assert.Len(t, sink.logs, 1)
+	assert.False(t, inTx(context.Background()))
+}
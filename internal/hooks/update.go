@@ -0,0 +1,81 @@
+package hooks
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+)
+
+// isUpdateDocument reports whether the payload of an update is a document, e.g. bson.M{"name": "x"},
+// rather than a model
+func isUpdateDocument(model interface{}) bool {
+	switch model.(type) {
+	case bson.M, map[string]interface{}, bson.D:
+		return true
+	}
+	return false
+}
+
+// updateFields returns the fields set by the payload of an update: a document or a struct.
+// Keys may be dotted paths of embedded documents, e.g. "address.city". Values are converted
+// the way the driver stores them, like the states they are merged into.
+func updateFields(model interface{}) (map[string]interface{}, error) {
+	switch m := model.(type) {
+	case bson.D:
+		fields := make(bson.M, len(m))
+		for _, e := range m {
+			fields[e.Key] = e.Value
+		}
+		return modelToState(fields)
+	default:
+		return modelToState(model)
+	}
+}
+
+// mergeState returns state with fields set, the dotted fields set the embedded documents of state
+func mergeState(state, fields map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(state)+len(fields))
+	for key, value := range state {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		setPath(merged, strings.Split(key, "."), value)
+	}
+	return merged
+}
+
+// setPath sets value at path in doc, copying the embedded documents it goes through. A path
+// crossing a value that isn't a document is set as one dotted key.
+func setPath(doc map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		doc[path[0]] = value
+		return
+	}
+	embedded, ok := documentMap(doc[path[0]])
+	if !ok {
+		if _, exists := doc[path[0]]; exists {
+			doc[strings.Join(path, ".")] = value
+			return
+		}
+		embedded = map[string]interface{}{}
+	}
+	embedded = mergeState(embedded, nil)
+	setPath(embedded, path[1:], value)
+	doc[path[0]] = embedded
+}
+
+// documentMap returns v as a map when it is an embedded document
+func documentMap(v interface{}) (map[string]interface{}, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return d, true
+	case bson.D:
+		m := make(map[string]interface{}, len(d))
+		for _, e := range d {
+			m[e.Key] = e.Value
+		}
+		return m, true
+	}
+	return nil, false
+}
@@ -0,0 +1,109 @@
+package hooks
+
+import (
+	"context"
+	in "github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestUpdateFields(t *testing.T) {
+	id := primitive.NewObjectID()
+	seen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		model interface{}
+		want  map[string]interface{}
+	}{
+		{name: "bson.M", model: bson.M{"name": "x", "owner": id}, want: map[string]interface{}{"name": "x", "owner": id.Hex()}},
+		{name: "map", model: map[string]interface{}{"age": 3}, want: map[string]interface{}{"age": int32(3)}},
+		{name: "stored types", model: bson.M{"seen": seen, "home": struct {
+			City string `bson:"city"`
+		}{City: "Dhaka"}}, want: map[string]interface{}{"seen": primitive.NewDateTimeFromTime(seen), "home": bson.M{"city": "Dhaka"}}},
+		{name: "bson.D", model: bson.D{{Key: "address.city", Value: "Dhaka"}}, want: map[string]interface{}{"address.city": "Dhaka"}},
+		{name: "struct", model: struct {
+			Name string `bson:"name"`
+			Age  int    `bson:"age,omitempty"`
+		}{Name: "x"}, want: map[string]interface{}{"name": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := updateFields(tt.model)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	state := map[string]interface{}{
+		"name":    "a",
+		"age":     30,
+		"address": map[string]interface{}{"city": "Dhaka", "zip": "1207"},
+		"tags":    "x",
+	}
+	merged := mergeState(state, map[string]interface{}{"name": "b", "address.city": "Sylhet", "tags.0": "y", "new.field": 1})
+	assert.Equal(t, map[string]interface{}{
+		"name":    "b",
+		"age":     30,
+		"address": map[string]interface{}{"city": "Sylhet", "zip": "1207"},
+		"tags":    "x",
+		"tags.0":  "y",
+		"new":     map[string]interface{}{"field": 1},
+	}, merged)
+	assert.Equal(t, "Dhaka", state["address"].(map[string]interface{})["city"], "the tracked state isn't modified")
+}
+
+func TestPlan_PartialUpdate(t *testing.T) {
+	h := NewDefaultHook(slog.Default(), nil)
+	partial := func(rec record, quiet bool) record {
+		rec.merge, rec.quiet = true, quiet
+		return rec
+	}
+
+	// Only the updated fields change, the others keep their tracked value
+	logs, writes := h.plan([]record{
+		partial(planRecord("update", "1", map[string]interface{}{"name": "b"}), true),
+	}, trackedState("1", map[string]interface{}{"name": "a", "age": 30}))
+	require.Len(t, logs, 1)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "a", New: "b"}}, logs[0].Change)
+	require.Len(t, writes, 1)
+
+	// Partial updates of untracked documents are skipped
+	logs, writes = h.plan([]record{
+		partial(planRecord("update", "2", map[string]interface{}{"name": "b"}), true),
+	}, map[string]*auditLogMetaState{})
+	assert.Empty(t, logs)
+	assert.Empty(t, writes)
+}
+
+func TestAudit_UpdateImages(t *testing.T) {
+	w := &captureWriter{}
+	h := NewDefaultHook(slog.Default(), nil)
+	h.w = w
+	id := primitive.NewObjectID()
+	ctx := in.WithImages(context.Background(), in.Images{
+		Before: bson.M{"_id": id, "name": "a", "age": int32(30)},
+		After:  bson.M{"_id": id, "name": "b", "age": int32(30)},
+	})
+
+	require.NoError(t, h.PostSave(ctx, bson.M{"name": "b"}, nil, "user", "update", id.Hex()))
+	require.Len(t, w.recs, 1)
+	rec := w.recs[0]
+	assert.Equal(t, map[string]interface{}{"_id": id.Hex(), "name": "b", "age": int32(30)}, rec.state)
+	assert.Equal(t, "a", rec.before["name"])
+	assert.False(t, rec.merge, "the after image is the whole document")
+	assert.True(t, rec.quiet)
+
+	// Without images the payload is merged into the tracked state
+	require.NoError(t, h.PostSave(context.Background(), bson.M{"name": "c"}, nil, "user", "update", id.Hex()))
+	require.Len(t, w.recs, 2)
+	assert.Equal(t, map[string]interface{}{"name": "c"}, w.recs[1].state)
+	assert.True(t, w.recs[1].merge)
+}
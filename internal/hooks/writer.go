@@ -0,0 +1,227 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	in "github.com/its-own/gaudit/in"
+	"github.com/its-own/gaudit/internal/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// record is the audit of a single write waiting to be applied to the audit collections
+type record struct {
+	log   entities.AuditLog
+	state map[string]interface{}
+	// before is the document state prior to the write if known, the baseline of untracked documents
+	before map[string]interface{}
+	// dedupe skips the record when the write was already audited, e.g. by the in-process hooks
+	dedupe bool
+	// merge marks a state holding only the fields set by an update, merged into the tracked state
+	merge bool
+	// quiet skips the record silently when the document isn't tracked, even with a before state,
+	// e.g. the update of a map that may belong to a collection that isn't audited
+	quiet bool
+	// written is the cluster time window of the write, zero when unknown, e.g. in a transaction
+	written in.WriteTime
+}
+
+// writer decides when records are applied
+type writer interface {
+	write(ctx context.Context, rec record) error
+	flush(ctx context.Context) error
+	close(ctx context.Context) error
+}
+
+// syncWriter applies every record right away, in the caller's goroutine
+type syncWriter struct {
+	apply func(ctx context.Context, recs []record) error
+}
+
+func (w *syncWriter) write(ctx context.Context, rec record) error {
+	return w.apply(ctx, []record{rec})
+}
+
+func (w *syncWriter) flush(context.Context) error { return nil }
+
+func (w *syncWriter) close(context.Context) error { return nil }
+
+// auditLogMetaState tracks the latest state of a document while a batch is applied
+type auditLogMetaState struct {
+	meta      entities.AuditLogMeta
+	isNew     bool
+	isDeleted bool
+}
+
+// apply diffs each record against the last known state of its document and writes
+// the audit logs and the new document states in bulk. Records must be in write order.
+func (h *DefaultHooks) apply(ctx context.Context, recs []record) error {
+	for _, rec := range recs {
+		h.redactState(rec.log.Collection, rec.state)
+		h.redactState(rec.log.Collection, rec.before)
+	}
+
+	// Retrieve the existing audit log metas of the touched documents in one round trip
+	var docIds []string
+	for _, rec := range recs {
+		if rec.log.Operation != "insert" || rec.dedupe || !rec.written.To.IsZero() {
+			docIds = append(docIds, rec.log.DocumentId)
+		}
+	}
+	states, err := h.findAuditLogMetas(ctx, docIds)
+	if err != nil {
+		return err
+	}
+
+	logs, metaWrites := h.plan(recs, states)
+	if len(logs) == 0 {
+		return nil
+	}
+	// The logs are written first, a meta never moves to a state whose change wasn't logged
+	if err := h.store.InsertLogs(ctx, logs); err != nil {
+		return err
+	}
+	if len(metaWrites) > 0 {
+		if err := h.store.WriteMetas(ctx, metaWrites); err != nil {
+			return err
+		}
+	}
+	h.publish(ctx, logs...)
+	return nil
+}
+
+// plan computes the audit logs of recs and the writes bringing the metas up to date,
+// states holds the known metas by document id and is updated as records are planned.
+func (h *DefaultHooks) plan(recs []record, states map[string]*auditLogMetaState) ([]entities.AuditLog, []driver.WriteModel) {
+	logs := make([]entities.AuditLog, 0, len(recs))
+	var (
+		order []string
+		stale []primitive.ObjectID
+	)
+	for _, rec := range recs {
+		docId := rec.log.DocumentId
+		state, ok := states[docId]
+		tracked := ok && !state.isDeleted
+		// The meta is at or past the write, the write was audited by the hooks or the change stream
+		// already. A hook write that the change stream audited first keeps the change_stream log.
+		if ok && !rec.written.To.IsZero() && !state.meta.ClusterTime.Before(rec.written.From) {
+			continue
+		}
+		switch {
+		case rec.log.Operation == "insert":
+			if rec.dedupe && tracked {
+				continue
+			}
+			// A document re-inserted after being deleted in this batch starts a new meta
+			if ok && !state.isNew {
+				stale = append(stale, state.meta.Id)
+			}
+			// Every field of a new document is recorded as added
+			state = &auditLogMetaState{meta: entities.AuditLogMeta{Id: metaID(rec)}, isNew: true}
+		case tracked:
+		case rec.log.Operation == "delete" && (rec.dedupe || rec.before == nil):
+			// Only deletes of audited documents are logged
+			continue
+		case rec.quiet:
+			continue
+		case rec.before != nil:
+			// An untracked document starts from its state before the write
+			state = &auditLogMetaState{meta: entities.AuditLogMeta{Id: metaID(rec), DocumentCurrentState: rec.before}, isNew: true}
+		default:
+			h.l.Error(fmt.Sprintf("Failed to find audit log meta of document %s in %s", docId, rec.log.Collection))
+			continue
+		}
+
+		// Compare document states and log changes
+		newState := rec.state
+		if rec.merge {
+			newState = mergeState(state.meta.DocumentCurrentState, rec.state)
+		}
+		changes := compareDocumentStates(state.meta.DocumentCurrentState, newState)
+		if rec.dedupe && len(changes) == 0 {
+			continue
+		}
+		log := rec.log
+		log.AuditMetaId = state.meta.Id.Hex()
+		log.Change = changes
+		logs = append(logs, log)
+
+		state.meta.DocumentCurrentState = newState
+		if rec.written.To.After(state.meta.ClusterTime) {
+			state.meta.ClusterTime = rec.written.To
+		}
+		state.isDeleted = rec.log.Operation == "delete"
+		states[docId] = state
+		order = append(order, docId)
+	}
+
+	// Persist the latest state of every touched document
+	var metaWrites []driver.WriteModel
+	for _, id := range stale {
+		metaWrites = append(metaWrites, driver.NewDeleteOneModel().SetFilter(bson.M{"_id": id}))
+	}
+	for _, docId := range uniq(order) {
+		state := states[docId]
+		if state.isDeleted {
+			if !state.isNew {
+				metaWrites = append(metaWrites, driver.NewDeleteOneModel().SetFilter(bson.M{"_id": state.meta.Id}))
+			}
+			continue
+		}
+		if state.isNew {
+			// Upserted by id, the retry of a partly applied batch doesn't create a second meta
+			metaWrites = append(metaWrites, driver.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": state.meta.Id}).SetReplacement(state.meta).SetUpsert(true))
+			continue
+		}
+		set := bson.M{"document_current_state": state.meta.DocumentCurrentState}
+		if !state.meta.ClusterTime.IsZero() {
+			set["cluster_time"] = state.meta.ClusterTime
+		}
+		metaWrites = append(metaWrites, driver.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": state.meta.Id}).
+			SetUpdate(bson.M{"$set": set}))
+	}
+	return logs, metaWrites
+}
+
+// metaID returns the id of the meta created by rec, derived from its log so that applying
+// the same records again creates the same meta
+func metaID(rec record) primitive.ObjectID {
+	if rec.log.Id.IsZero() {
+		return primitive.NewObjectID()
+	}
+	return rec.log.Id
+}
+
+// findAuditLogMetas retrieves the existing audit log metas by document ID.
+func (h *DefaultHooks) findAuditLogMetas(ctx context.Context, docIds []string) (map[string]*auditLogMetaState, error) {
+	states := make(map[string]*auditLogMetaState, len(docIds))
+	if len(docIds) == 0 {
+		return states, nil
+	}
+	metas, err := h.store.FindMetas(ctx, uniq(docIds))
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range metas {
+		if docId, ok := meta.DocumentCurrentState["_id"].(string); ok {
+			states[docId] = &auditLogMetaState{meta: meta}
+		}
+	}
+	return states, nil
+}
+
+// uniq returns values without duplicates, keeping the first occurrence
+func uniq(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"github.com/its-own/gaudit/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"testing"
+)
+
+func planRecord(ops, docId string, state map[string]interface{}) record {
+	return record{log: entities.AuditLog{Collection: "user", Operation: ops, DocumentId: docId}, state: state}
+}
+
+func trackedState(docId string, state map[string]interface{}) map[string]*auditLogMetaState {
+	state["_id"] = docId
+	return map[string]*auditLogMetaState{
+		docId: {meta: entities.AuditLogMeta{Id: primitive.NewObjectID(), DocumentCurrentState: state}},
+	}
+}
+
+func TestPlan_InsertUpdateDelete(t *testing.T) {
+	h := NewDefaultHook(slog.Default(), nil)
+	logs, writes := h.plan([]record{
+		planRecord("insert", "1", map[string]interface{}{"_id": "1", "name": "a"}),
+		planRecord("update", "1", map[string]interface{}{"_id": "1", "name": "b"}),
+	}, map[string]*auditLogMetaState{})
+
+	require.Len(t, logs, 2)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "<nil>", New: "a"}}, logs[0].Change)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "a", New: "b"}}, logs[1].Change)
+	assert.Equal(t, logs[0].AuditMetaId, logs[1].AuditMetaId)
+	// One meta insert holding the latest state
+	require.Len(t, writes, 1)
+	insert, ok := writes[0].(*driver.ReplaceOneModel)
+	require.True(t, ok)
+	assert.True(t, *insert.Upsert)
+	assert.Equal(t, "b", insert.Replacement.(entities.AuditLogMeta).DocumentCurrentState["name"])
+
+	logs, writes = h.plan([]record{
+		planRecord("delete", "2", nil),
+	}, trackedState("2", map[string]interface{}{"name": "c"}))
+	require.Len(t, logs, 1)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "c", New: ""}}, logs[0].Change)
+	require.Len(t, writes, 1)
+	assert.IsType(t, &driver.DeleteOneModel{}, writes[0])
+}
+
+func TestPlan_UntrackedDocuments(t *testing.T) {
+	h := NewDefaultHook(slog.Default(), nil)
+
+	// Updates and deletes of documents gaudit never saw are skipped
+	logs, writes := h.plan([]record{
+		planRecord("update", "1", map[string]interface{}{"name": "a"}),
+		planRecord("delete", "2", nil),
+	}, map[string]*auditLogMetaState{})
+	assert.Empty(t, logs)
+	assert.Empty(t, writes)
+
+	// unless the state before the write is known
+	rec := planRecord("update", "1", map[string]interface{}{"_id": "1", "name": "b"})
+	rec.before = map[string]interface{}{"_id": "1", "name": "a"}
+	logs, writes = h.plan([]record{rec}, map[string]*auditLogMetaState{})
+	require.Len(t, logs, 1)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "a", New: "b"}}, logs[0].Change)
+	assert.Len(t, writes, 1)
+}
+
+func TestPlan_Dedupe(t *testing.T) {
+	h := NewDefaultHook(slog.Default(), nil)
+	dedupe := func(rec record) record {
+		rec.dedupe = true
+		return rec
+	}
+
+	// The hooks already audited this state
+	logs, writes := h.plan([]record{
+		dedupe(planRecord("update", "1", map[string]interface{}{"_id": "1", "name": "a"})),
+	}, trackedState("1", map[string]interface{}{"name": "a"}))
+	assert.Empty(t, logs)
+	assert.Empty(t, writes)
+
+	logs, _ = h.plan([]record{
+		dedupe(planRecord("insert", "1", map[string]interface{}{"_id": "1", "name": "a"})),
+	}, trackedState("1", map[string]interface{}{"name": "a"}))
+	assert.Empty(t, logs)
+
+	deleted := dedupe(planRecord("delete", "1", nil))
+	deleted.before = map[string]interface{}{"_id": "1", "name": "a"}
+	logs, _ = h.plan([]record{deleted}, map[string]*auditLogMetaState{})
+	assert.Empty(t, logs)
+
+	// A change made behind gaudit's back is audited
+	logs, _ = h.plan([]record{
+		dedupe(planRecord("update", "1", map[string]interface{}{"_id": "1", "name": "b"})),
+	}, trackedState("1", map[string]interface{}{"name": "a"}))
+	require.Len(t, logs, 1)
+	assert.Equal(t, map[string]entities.AuditChange{"name": {Old: "a", New: "b"}}, logs[0].Change)
+}
+
+func TestRedactState(t *testing.T) {
+	h := NewDefaultHook(slog.Default(), nil).WithRedaction(map[string][]string{"user": {"password"}, "*": {"ssn"}})
+	state := map[string]interface{}{"_id": "1", "password": "secret", "ssn": "123", "name": "John"}
+	h.redactState("user", state)
+	assert.Equal(t, map[string]interface{}{"_id": "1", "password": Redacted, "ssn": Redacted, "name": "John"}, state)
+
+	state = map[string]interface{}{"password": "secret", "ssn": "123"}
+	h.redactState("order", state)
+	assert.Equal(t, map[string]interface{}{"password": "secret", "ssn": Redacted}, state)
+}
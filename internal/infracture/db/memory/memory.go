@@ -0,0 +1,845 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	in "github.com/its-own/gaudit/in"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a db.NoSql keeping collections in memory, for unit tests. Writes run the hooks
+// exactly like the Mongo implementation. Documents are stored as the driver would encode them,
+// filters support the comparison, $in, $nin, $exists, $not, $and, $or and $nor operators on
+// dotted paths, updates $set, $unset and $inc, and unique indexes are enforced.
+type Memory struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+	indexes     map[string][]db.Index
+	hook        in.HookV2
+	softDelete  []string
+}
+
+// flusher and closer are implemented by hooks that write audit logs in the background
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+type closer interface {
+	Close(ctx context.Context) error
+}
+
+// txHook is implemented by hooks that hold back side effects until a transaction commits
+type txHook interface {
+	BeginTx(ctx context.Context) context.Context
+	CommitTx(ctx context.Context)
+}
+
+// InitMemory returns an empty in-memory database running hook on writes, hook may be nil
+func InitMemory(hook in.HookV2) *Memory {
+	return &Memory{
+		collections: make(map[string][]bson.M),
+		indexes:     make(map[string][]db.Index),
+		hook:        hook,
+	}
+}
+
+// WithHook sets the hook run on writes, e.g. an audit hook keeping its audit trail in d
+func (d *Memory) WithHook(hook in.HookV2) *Memory {
+	d.hook = hook
+	return d
+}
+
+// WithSoftDelete makes the deletes of the given collections set the deleted_at field of the docs
+// instead of removing them, FindOne, List and Count skip the soft-deleted docs
+func (d *Memory) WithSoftDelete(cols ...string) *Memory {
+	d.softDelete = cols
+	return d
+}
+
+func (d *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Disconnect writes the pending audit logs
+func (d *Memory) Disconnect(ctx context.Context) (err error) {
+	defer wrap(&err, "", "disconnect")
+	if c, ok := d.hook.(closer); ok {
+		return c.Close(ctx)
+	}
+	return nil
+}
+
+// Flush waits until every pending audit log is written
+func (d *Memory) Flush(ctx context.Context) (err error) {
+	defer wrap(&err, "", "flush")
+	if f, ok := d.hook.(flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// EnsureIndices adds indices to collection col, only unique indices have an effect
+func (d *Memory) EnsureIndices(ctx context.Context, col string, index []db.Index) (err error) {
+	defer wrap(&err, col, "ensure_indices")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexes := append(append([]db.Index(nil), d.indexes[col]...), index...)
+	docs := d.collections[col]
+	for i := range docs {
+		if err := checkUnique(indexes, docs[:i], docs[i]); err != nil {
+			return err
+		}
+	}
+	d.indexes[col] = indexes
+	return nil
+}
+
+// DropIndices drops indices from collection col
+func (d *Memory) DropIndices(ctx context.Context, col string, index []db.Index) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.indexes, col)
+	return nil
+}
+
+// Insert inserts doc into collection
+func (d *Memory) Insert(ctx context.Context, col string, doc interface{}) (err error) {
+	defer wrap(&err, col, "insert")
+	if err := d.preSave(ctx, doc, nil, col, "insert"); err != nil {
+		return err
+	}
+	id, err := d.insert(col, doc)
+	if err != nil {
+		return err
+	}
+	return d.postSave(ctx, doc, nil, col, "insert", in.DocumentID(id))
+}
+
+func (d *Memory) InsertMany(ctx context.Context, col string, docs []interface{}) (err error) {
+	defer wrap(&err, col, "insert_many")
+	for _, doc := range docs {
+		if _, err := d.insert(col, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindOne finds a doc by query
+func (d *Memory) FindOne(ctx context.Context, col string, q interface{}, v interface{}, sort ...interface{}) (err error) {
+	defer wrap(&err, col, "find_one")
+	var spec interface{}
+	if len(sort) > 0 {
+		spec = sort[0]
+	}
+	docs, err := d.find(col, d.readFilter(ctx, col, q), spec, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return db.ErrNotFound
+	}
+	return decode(docs[0], v)
+}
+
+// List finds list of docs that matches query with skip and limit
+func (d *Memory) List(ctx context.Context, col string, filter interface{}, skip, limit int64, v interface{}, sort ...interface{}) (err error) {
+	defer wrap(&err, col, "list")
+	var spec interface{}
+	if len(sort) > 0 {
+		spec = sort[0]
+	}
+	docs, err := d.find(col, d.readFilter(ctx, col, filter), spec, skip, limit)
+	if err != nil {
+		return err
+	}
+	return decodeAll(docs, v)
+}
+
+// Aggregate runs the $match, $sort, $skip and $limit stages of q on docs and store the result on v
+func (d *Memory) Aggregate(ctx context.Context, col string, q []interface{}, v interface{}) (err error) {
+	defer wrap(&err, col, "aggregate")
+	docs, err := d.find(col, nil, nil, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, s := range q {
+		stage, err := toOrderedDoc(s)
+		if err != nil {
+			return err
+		}
+		if len(stage) != 1 {
+			return fmt.Errorf("memory: an aggregation stage has one operator")
+		}
+		if docs, err = aggregate(docs, stage[0]); err != nil {
+			return err
+		}
+	}
+	return decodeAll(docs, v)
+}
+
+func (d *Memory) AggregateWithDiskUse(ctx context.Context, col string, q []interface{}, v interface{}) error {
+	return d.Aggregate(ctx, col, q, v)
+}
+
+func (d *Memory) Distinct(ctx context.Context, col, field string, q interface{}, v interface{}) (err error) {
+	defer wrap(&err, col, "distinct")
+	docs, err := d.find(col, q, nil, 0, 0)
+	if err != nil {
+		return err
+	}
+	var values []interface{}
+	for _, doc := range docs {
+		value, ok := lookup(doc, field)
+		if !ok {
+			continue
+		}
+		seen := false
+		for _, other := range values {
+			if c, ok := compare(value, other); ok && c == 0 {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			values = append(values, value)
+		}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (d *Memory) PartialUpdateMany(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer wrap(&err, col, "update_many")
+	_, err = d.update(col, filter, bson.M{"$set": data}, true, false)
+	return err
+}
+
+func (d *Memory) PartialUpdateManyByQuery(ctx context.Context, col string, filter interface{}, query db.UnorderedDbQuery) (err error) {
+	defer wrap(&err, col, "update_many")
+	_, err = d.update(col, filter, bson.M(query), true, false)
+	return err
+}
+
+// BulkUpdate applies the InsertOne, UpdateOne, UpdateMany, ReplaceOne, DeleteOne and
+// DeleteMany models in order
+func (d *Memory) BulkUpdate(ctx context.Context, col string, models []mongo.WriteModel) (err error) {
+	defer wrap(&err, col, "bulk_write")
+	for _, model := range models {
+		var err error
+		switch m := model.(type) {
+		case *mongo.InsertOneModel:
+			_, err = d.insert(col, m.Document)
+		case *mongo.UpdateOneModel:
+			_, err = d.update(col, m.Filter, m.Update, false, m.Upsert != nil && *m.Upsert)
+		case *mongo.UpdateManyModel:
+			_, err = d.update(col, m.Filter, m.Update, true, m.Upsert != nil && *m.Upsert)
+		case *mongo.ReplaceOneModel:
+			_, err = d.update(col, m.Filter, m.Replacement, false, m.Upsert != nil && *m.Upsert)
+		case *mongo.DeleteOneModel:
+			_, err = d.delete(col, m.Filter, false)
+		case *mongo.DeleteManyModel:
+			_, err = d.delete(col, m.Filter, true)
+		default:
+			err = fmt.Errorf("memory: unsupported write model %T", model)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMany deletes the docs matching filter, the deletes of audited docs are audited. In a
+// soft-delete collection the docs are soft-deleted instead.
+func (d *Memory) DeleteMany(ctx context.Context, col string, filter interface{}) (err error) {
+	defer wrap(&err, col, "delete")
+	if d.isSoftDelete(col) {
+		_, err := d.setDeleted(ctx, col, filter, true, "soft_delete")
+		return err
+	}
+	if err := d.preSave(ctx, nil, filter, col, "delete"); err != nil {
+		return err
+	}
+	docs, err := d.delete(col, filter, true)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := d.postSave(ctx, nil, filter, col, "delete", in.DocumentID(doc["_id"])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteOne deletes the first doc matching filter, its delete is audited if the doc is audited.
+// In a soft-delete collection the doc is soft-deleted instead.
+func (d *Memory) DeleteOne(ctx context.Context, col string, filter interface{}) (err error) {
+	defer wrap(&err, col, "delete")
+	if err := d.findOneAndDelete(ctx, col, filter, nil); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindOneAndDelete deletes the first doc matching filter like DeleteOne and decodes it into v,
+// it returns db.ErrNotFound when no doc matches. PreSave receives v decoded from the doc before
+// it is deleted, so BeforeDelete can veto the delete, and PostSave receives v as the model and
+// the deleted doc as the before image.
+func (d *Memory) FindOneAndDelete(ctx context.Context, col string, filter interface{}, v interface{}) (err error) {
+	defer wrap(&err, col, "delete")
+	return d.findOneAndDelete(ctx, col, filter, v)
+}
+
+func (d *Memory) findOneAndDelete(ctx context.Context, col string, filter interface{}, v interface{}) error {
+	if d.isSoftDelete(col) {
+		docs, err := d.setDeleted(ctx, col, filter, false, "soft_delete")
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return db.ErrNotFound
+		}
+		return decodeInto(docs[0], v)
+	}
+	query := filter
+	if v == nil {
+		if err := d.preSave(ctx, nil, filter, col, "delete"); err != nil {
+			return err
+		}
+	} else {
+		// The doc is read first, so the PreSave hooks of v, BeforeDelete included, see the doc they delete
+		docs, err := d.find(col, filter, nil, 0, 1)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return db.ErrNotFound
+		}
+		if err := decodeInto(docs[0], v); err != nil {
+			return err
+		}
+		if err := d.preSave(ctx, v, filter, col, "delete"); err != nil {
+			return err
+		}
+		query = bson.M{"_id": docs[0]["_id"]}
+	}
+	docs, err := d.delete(col, query, false)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return db.ErrNotFound
+	}
+	if err := decodeInto(docs[0], v); err != nil {
+		return err
+	}
+	ctx = in.WithImages(ctx, in.Images{Before: docs[0]})
+	return d.postSave(ctx, v, filter, col, "delete", in.DocumentID(docs[0]["_id"]))
+}
+
+// Restore undoes the soft delete of the docs matching filter, the restores of audited docs are audited
+func (d *Memory) Restore(ctx context.Context, col string, filter interface{}) (err error) {
+	defer wrap(&err, col, "restore")
+	_, err = d.setDeleted(ctx, col, filter, true, "restore")
+	return err
+}
+
+// setDeleted soft-deletes the first or every doc matching filter that isn't soft-deleted, or
+// restores the soft-deleted ones when ops is "restore". It returns the docs as they were before.
+// PostSave receives each doc before and after the write.
+func (d *Memory) setDeleted(ctx context.Context, col string, filter interface{}, many bool, ops string) ([]bson.M, error) {
+	if err := d.preSave(ctx, nil, filter, col, ops); err != nil {
+		return nil, err
+	}
+	state := bson.M{db.DeletedAt: nil}
+	update := bson.M{"$set": bson.M{db.DeletedAt: primitive.NewDateTimeFromTime(time.Now())}}
+	if ops == "restore" {
+		state = bson.M{db.DeletedAt: bson.M{"$ne": nil}}
+		update = bson.M{"$unset": bson.M{db.DeletedAt: ""}}
+	}
+	query := interface{}(state)
+	if filter != nil {
+		query = bson.M{"$and": bson.A{filter, state}}
+	}
+	updated, err := d.update(col, query, update, many, false)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]bson.M, 0, len(updated))
+	for _, doc := range updated {
+		ctx := in.WithImages(ctx, in.Images{Before: doc.before, After: doc.after})
+		if err := d.postSave(ctx, nil, filter, col, ops, in.DocumentID(doc.after["_id"])); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc.before)
+	}
+	return docs, nil
+}
+
+// isSoftDelete reports whether col is a soft-delete collection
+func (d *Memory) isSoftDelete(col string) bool {
+	for _, c := range d.softDelete {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// readFilter restricts filter to the docs that aren't soft-deleted when col is a soft-delete
+// collection, unless ctx comes from db.WithDeleted
+func (d *Memory) readFilter(ctx context.Context, col string, filter interface{}) interface{} {
+	if !d.isSoftDelete(col) || db.IncludesDeleted(ctx) {
+		return filter
+	}
+	return db.NotDeleted(filter)
+}
+
+func (d *Memory) Count(ctx context.Context, col string, q interface{}) (cnt int64, err error) {
+	defer wrap(&err, col, "count")
+	docs, err := d.find(col, d.readFilter(ctx, col, q), nil, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+// Update sets data on the first doc matching filter. PostSave receives the doc as stored
+// before and after the update, see in.ImagesFrom. When data is a versioned model, see
+// in.VersionTag, only a doc at its version is updated, the version is incremented in the doc
+// and in data, and a *db.ConflictError is returned when the doc is at another version.
+func (d *Memory) Update(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer wrap(&err, col, "update")
+	var (
+		query  = filter
+		update = bson.M{"$set": data}
+	)
+	field, version, versioned := in.VersionOf(data)
+	if versioned {
+		var err error
+		if query, update, err = versionedUpdate(filter, data, field, version); err != nil {
+			return err
+		}
+	}
+	if err := d.preSave(ctx, data, filter, col, "update"); err != nil {
+		return err
+	}
+	updated, err := d.update(col, query, update, false, false)
+	if err != nil {
+		return err
+	}
+	if len(updated) == 0 {
+		if versioned {
+			// The version check failed if the doc exists at all
+			if docs, err := d.find(col, filter, nil, 0, 1); err == nil && len(docs) > 0 {
+				return &db.ConflictError{Collection: col, Version: version}
+			}
+		}
+		return db.ErrNotFound
+	}
+	if versioned {
+		// A model passed by value can't be set, its audit log reads the version of the stored doc
+		in.SetVersion(data, version+1)
+	}
+	ctx = in.WithImages(ctx, in.Images{Before: updated[0].before, After: updated[0].after})
+	return d.postSave(ctx, data, filter, col, "update", in.DocumentID(updated[0].after["_id"]))
+}
+
+// versionedUpdate returns the query and the update of an Update of model, a model whose version
+// field is field: the query matches the doc at version, a doc without version being at 0, and
+// the update increments it
+func versionedUpdate(filter, model interface{}, field string, version int64) (interface{}, bson.M, error) {
+	set, err := toDoc(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(set, field)
+	current := interface{}(version)
+	if version == 0 {
+		current = bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	query := bson.M{field: current}
+	if filter != nil {
+		query = bson.M{"$and": bson.A{filter, query}}
+	}
+	return query, bson.M{"$set": set, "$inc": bson.M{field: 1}}, nil
+}
+
+// Upsert sets data on the first doc matching filter, or inserts a doc made of the equality
+// fields of filter and data when none matches. PostSave receives the doc as stored before and
+// after the write, the before image is nil when the doc was inserted.
+func (d *Memory) Upsert(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer wrap(&err, col, "upsert")
+	if err := d.preSave(ctx, data, filter, col, "upsert"); err != nil {
+		return err
+	}
+	return d.upsert(ctx, col, filter, bson.M{"$set": data}, data, "upsert")
+}
+
+// Replace replaces the first doc matching filter by doc, or inserts doc when none matches.
+// PostSave receives the doc as stored before and after the write, the before image is nil
+// when the doc was inserted.
+func (d *Memory) Replace(ctx context.Context, col string, filter interface{}, doc interface{}) (err error) {
+	defer wrap(&err, col, "replace")
+	if err := d.preSave(ctx, doc, filter, col, "replace"); err != nil {
+		return err
+	}
+	return d.upsert(ctx, col, filter, doc, doc, "replace")
+}
+
+func (d *Memory) upsert(ctx context.Context, col string, filter, update, model interface{}, ops string) error {
+	updated, err := d.update(col, filter, update, false, true)
+	if err != nil {
+		return err
+	}
+	ctx = in.WithImages(ctx, in.Images{Before: updated[0].before, After: updated[0].after})
+	return d.postSave(ctx, model, filter, col, ops, in.DocumentID(updated[0].after["_id"]))
+}
+
+type txKey struct{}
+
+// inTransaction reports whether ctx belongs to a WithTransaction callback
+func inTransaction(ctx context.Context) bool {
+	return ctx.Value(txKey{}) != nil
+}
+
+// WithTransaction runs fn and rolls back every collection if it fails. The transaction isn't
+// isolated: writes made concurrently by other goroutines are rolled back with it.
+func (d *Memory) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return fn(ctx)
+	}
+	snapshot := d.snapshot()
+	txCtx := context.WithValue(ctx, txKey{}, true)
+	if h, ok := d.hook.(txHook); ok {
+		txCtx = h.BeginTx(txCtx)
+	}
+	if err := fn(txCtx); err != nil {
+		d.mu.Lock()
+		d.collections = snapshot
+		d.mu.Unlock()
+		return err
+	}
+	if h, ok := d.hook.(txHook); ok {
+		h.CommitTx(txCtx)
+	}
+	return nil
+}
+
+// wrap attaches col and ops to *err, the failure of operation ops on col, like the Mongo
+// implementation does
+func wrap(err *error, col, ops string) {
+	*err = db.WrapOp(*err, col, ops, nil)
+}
+
+// preSave runs the PreSave hook, an error vetoes the write and is wrapped with db.ErrAborted
+func (d *Memory) preSave(ctx context.Context, model interface{}, filter interface{}, col, ops string) error {
+	if d.hook == nil {
+		return nil
+	}
+	if err := d.hook.PreSave(ctx, model, filter, col, ops, ""); err != nil {
+		return fmt.Errorf("%w: %w", db.ErrAborted, err)
+	}
+	return nil
+}
+
+// postSave runs the PostSave hook, the hook's failure policy decides which failures are returned
+func (d *Memory) postSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	if d.hook == nil {
+		return nil
+	}
+	return d.hook.PostSave(ctx, model, filter, col, ops, docId)
+}
+
+// insert stores doc and returns its _id, an ObjectID is generated when it has none
+func (d *Memory) insert(col string, v interface{}) (interface{}, error) {
+	doc, err := toDoc(v)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	docs := d.collections[col]
+	for _, other := range docs {
+		if c, ok := compare(doc["_id"], other["_id"]); ok && c == 0 {
+			return nil, fmt.Errorf("%w: _id %v", db.ErrDuplicateKey, doc["_id"])
+		}
+	}
+	if err := checkUnique(d.indexes[col], docs, doc); err != nil {
+		return nil, err
+	}
+	d.collections[col] = append(docs, doc)
+	return doc["_id"], nil
+}
+
+// find returns copies of the docs matching filter
+func (d *Memory) find(col string, filter interface{}, spec interface{}, skip, limit int64) ([]bson.M, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	var docs []bson.M
+	for _, doc := range d.collections[col] {
+		ok, err := match(doc, f)
+		if err != nil {
+			d.mu.Unlock()
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+	d.mu.Unlock()
+	if err := sortDocs(docs, spec); err != nil {
+		return nil, err
+	}
+	return page(docs, skip, limit), nil
+}
+
+// image is a doc before and after an update, before is nil for an upserted doc
+type image struct {
+	before bson.M
+	after  bson.M
+}
+
+// update applies update to the first or every doc matching filter, inserting a doc built from
+// the filter and the update when none matches and upsert is set. It returns the updated docs.
+func (d *Memory) update(col string, filter interface{}, update interface{}, many, upsert bool) ([]image, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	docs := d.collections[col]
+	var updated []image
+	for i, doc := range docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		next := copyDoc(doc)
+		if err := applyUpdate(next, u); err != nil {
+			return nil, err
+		}
+		others := append(append([]bson.M(nil), docs[:i]...), docs[i+1:]...)
+		if err := checkUnique(d.indexes[col], others, next); err != nil {
+			return nil, err
+		}
+		docs[i] = next
+		updated = append(updated, image{before: copyDoc(doc), after: copyDoc(next)})
+		if !many {
+			break
+		}
+	}
+	if len(updated) > 0 || !upsert {
+		return updated, nil
+	}
+
+	doc := bson.M{}
+	for key, value := range f {
+		if !strings.HasPrefix(key, "$") {
+			if cond, ok := asMap(value); !ok || !isOperatorDoc(cond) {
+				set(doc, key, value)
+			}
+		}
+	}
+	if err := applyUpdate(doc, u); err != nil {
+		return nil, err
+	}
+	if onInsert, ok := asMap(u["$setOnInsert"]); ok {
+		for key, value := range onInsert {
+			set(doc, key, value)
+		}
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	if err := checkUnique(d.indexes[col], docs, doc); err != nil {
+		return nil, err
+	}
+	d.collections[col] = append(docs, doc)
+	return []image{{after: copyDoc(doc)}}, nil
+}
+
+// delete removes the first or every doc matching filter and returns them
+func (d *Memory) delete(col string, filter interface{}, many bool) ([]bson.M, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var kept, deleted []bson.M
+	for _, doc := range d.collections[col] {
+		ok, err := match(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (many || len(deleted) == 0) {
+			deleted = append(deleted, doc)
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	d.collections[col] = kept
+	return deleted, nil
+}
+
+func (d *Memory) snapshot() map[string][]bson.M {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	snapshot := make(map[string][]bson.M, len(d.collections))
+	for col, docs := range d.collections {
+		copies := make([]bson.M, len(docs))
+		for i, doc := range docs {
+			copies[i] = copyDoc(doc)
+		}
+		snapshot[col] = copies
+	}
+	return snapshot
+}
+
+// checkUnique returns db.ErrDuplicateKey when doc has the same keys as one of docs on a unique index
+func checkUnique(indexes []db.Index, docs []bson.M, doc bson.M) error {
+	for _, index := range indexes {
+		if index.Unique == nil || !*index.Unique {
+			continue
+		}
+		for _, other := range docs {
+			if sameKeys(index, doc, other) {
+				return fmt.Errorf("%w: index %s", db.ErrDuplicateKey, index.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func sameKeys(index db.Index, a, b bson.M) bool {
+	missing := true
+	for _, key := range index.Keys {
+		x, okA := lookup(a, key.Key)
+		y, okB := lookup(b, key.Key)
+		if okA || okB {
+			missing = false
+		}
+		if c, ok := compare(x, y); !ok || c != 0 {
+			return false
+		}
+	}
+	// Sparse indexes skip the docs without the indexed fields
+	return !missing || index.Sparse == nil || !*index.Sparse
+}
+
+func aggregate(docs []bson.M, stage bson.E) ([]bson.M, error) {
+	switch stage.Key {
+	case "$match":
+		filter, ok := asMap(stage.Value)
+		if !ok {
+			return nil, fmt.Errorf("memory: $match takes a document")
+		}
+		var matched []bson.M
+		for _, doc := range docs {
+			ok, err := match(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = append(matched, doc)
+			}
+		}
+		return matched, nil
+	case "$sort":
+		return docs, sortDocs(docs, stage.Value)
+	case "$skip":
+		n, _ := number(stage.Value)
+		return page(docs, int64(n), 0), nil
+	case "$limit":
+		n, _ := number(stage.Value)
+		return page(docs, 0, int64(n)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, stage.Key)
+}
+
+func page(docs []bson.M, skip, limit int64) []bson.M {
+	if skip >= int64(len(docs)) {
+		return nil
+	}
+	docs = docs[skip:]
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// copyDoc deep copies doc, so callers never share the stored documents
+func copyDoc(doc bson.M) bson.M {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	var c bson.M
+	if err := bson.Unmarshal(data, &c); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func decode(doc bson.M, v interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// decodeInto decodes doc into v, v may be nil
+func decodeInto(doc bson.M, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	return decode(doc, v)
+}
+
+// decodeAll decodes docs into v, a pointer to a slice
+func decodeAll(docs []bson.M, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("memory: results must be decoded into a pointer to a slice")
+	}
+	slice := reflect.MakeSlice(rv.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		elem := reflect.New(slice.Type().Elem())
+		if err := decode(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+	rv.Elem().Set(slice)
+	return nil
+}
@@ -0,0 +1,460 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"github.com/its-own/gaudit/internal/hooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type item struct {
+	Id    string   `bson:"_id"`
+	Name  string   `bson:"name"`
+	Price int      `bson:"price"`
+	Tags  []string `bson:"tags,omitempty"`
+	Shop  struct {
+		City string `bson:"city"`
+	} `bson:"shop"`
+}
+
+func seed(t *testing.T) *Memory {
+	t.Helper()
+	d := InitMemory(nil)
+	items := []item{
+		{Id: "a", Name: "apple", Price: 3, Tags: []string{"fruit", "red"}},
+		{Id: "b", Name: "bread", Price: 5},
+		{Id: "c", Name: "cherry", Price: 8, Tags: []string{"fruit"}},
+	}
+	items[0].Shop.City = "Dhaka"
+	items[2].Shop.City = "Sylhet"
+	for _, it := range items {
+		require.NoError(t, d.InsertMany(context.Background(), "items", []interface{}{it}))
+	}
+	return d
+}
+
+func TestMemory_List(t *testing.T) {
+	ctx := context.Background()
+	d := seed(t)
+	tests := []struct {
+		name   string
+		filter interface{}
+		sort   interface{}
+		skip   int64
+		limit  int64
+		want   []string
+	}{
+		{name: "all", filter: bson.M{}, want: []string{"a", "b", "c"}},
+		{name: "equality", filter: bson.M{"name": "bread"}, want: []string{"b"}},
+		{name: "array contains", filter: bson.M{"tags": "fruit"}, want: []string{"a", "c"}},
+		{name: "dotted path", filter: bson.M{"shop.city": "Sylhet"}, want: []string{"c"}},
+		{name: "range", filter: bson.M{"price": bson.M{"$gt": 3, "$lte": 8}}, want: []string{"b", "c"}},
+		{name: "in", filter: bson.M{"_id": bson.M{"$in": []string{"a", "c", "z"}}}, want: []string{"a", "c"}},
+		{name: "nin", filter: bson.M{"_id": bson.M{"$nin": []string{"a"}}}, want: []string{"b", "c"}},
+		{name: "exists", filter: bson.M{"tags": bson.M{"$exists": false}}, want: []string{"b"}},
+		{name: "ne", filter: bson.M{"name": bson.M{"$ne": "apple"}}, want: []string{"b", "c"}},
+		{name: "null matches missing", filter: bson.M{"tags": nil}, want: []string{"b"}},
+		{name: "ne null", filter: bson.M{"tags": bson.M{"$ne": nil}}, want: []string{"a", "c"}},
+		{name: "or", filter: bson.M{"$or": bson.A{bson.M{"name": "apple"}, bson.M{"price": 8}}}, want: []string{"a", "c"}},
+		{name: "sort desc", filter: bson.M{}, sort: bson.D{{Key: "price", Value: -1}}, want: []string{"c", "b", "a"}},
+		{name: "skip limit", filter: bson.M{}, sort: bson.M{"name": 1}, skip: 1, limit: 1, want: []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []item
+			var sort []interface{}
+			if tt.sort != nil {
+				sort = append(sort, tt.sort)
+			}
+			require.NoError(t, d.List(ctx, "items", tt.filter, tt.skip, tt.limit, &items, sort...))
+			var ids []string
+			for _, it := range items {
+				ids = append(ids, it.Id)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestMemory_Writes(t *testing.T) {
+	ctx := context.Background()
+	d := seed(t)
+
+	require.NoError(t, d.Update(ctx, "items", bson.M{"_id": "a"}, bson.M{"price": 4, "shop.city": "Khulna"}))
+	var got item
+	require.NoError(t, d.FindOne(ctx, "items", bson.M{"_id": "a"}, &got))
+	assert.Equal(t, 4, got.Price)
+	assert.Equal(t, "Khulna", got.Shop.City)
+	assert.Equal(t, "apple", got.Name, "$set keeps the other fields")
+	assert.ErrorIs(t, d.Update(ctx, "items", bson.M{"_id": "z"}, bson.M{"price": 1}), db.ErrNotFound)
+
+	require.NoError(t, d.PartialUpdateManyByQuery(ctx, "items", bson.M{"tags": "fruit"}, db.UnorderedDbQuery{"$inc": bson.M{"price": 1}}))
+	count, err := d.Count(ctx, "items", bson.M{"price": bson.M{"$in": bson.A{5, 9}}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	require.NoError(t, d.BulkUpdate(ctx, "items", []mongo.WriteModel{
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": "d"}).SetUpdate(bson.M{"$set": bson.M{"name": "date"}}).SetUpsert(true),
+		mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": "b"}),
+	}))
+	var names []string
+	require.NoError(t, d.Distinct(ctx, "items", "name", bson.M{}, &names))
+	assert.ElementsMatch(t, []string{"apple", "cherry", "date"}, names)
+
+	require.NoError(t, d.DeleteMany(ctx, "items", bson.M{"price": bson.M{"$gte": 5}}))
+	assert.ErrorIs(t, d.FindOne(ctx, "items", bson.M{"_id": "a"}, &got), db.ErrNotFound)
+}
+
+func TestMemory_UniqueIndex(t *testing.T) {
+	ctx := context.Background()
+	d := seed(t)
+	unique := true
+	require.NoError(t, d.EnsureIndices(ctx, "items", []db.Index{{Name: "name", Keys: []db.IndexKey{{Key: "name", Asc: 1}}, Unique: &unique}}))
+
+	err := d.InsertMany(ctx, "items", []interface{}{item{Id: "z", Name: "apple"}})
+	assert.ErrorIs(t, err, db.ErrDuplicateKey)
+	var opErr *db.OpError
+	require.ErrorAs(t, err, &opErr)
+	assert.Equal(t, db.OpError{Collection: "items", Operation: "insert_many", Err: opErr.Err}, *opErr)
+	assert.ErrorIs(t, d.Update(ctx, "items", bson.M{"_id": "b"}, bson.M{"name": "cherry"}), db.ErrDuplicateKey)
+	assert.ErrorIs(t, d.InsertMany(ctx, "items", []interface{}{item{Id: "a"}}), db.ErrDuplicateKey, "_id is always unique")
+
+	require.NoError(t, d.DropIndices(ctx, "items", nil))
+	assert.NoError(t, d.InsertMany(ctx, "items", []interface{}{item{Id: "z", Name: "apple"}}))
+}
+
+func TestMemory_WithTransaction(t *testing.T) {
+	ctx := context.Background()
+	d := seed(t)
+	failed := errors.New("failed")
+	err := d.WithTransaction(ctx, func(ctx context.Context) error {
+		require.NoError(t, d.DeleteMany(ctx, "items", bson.M{}))
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	count, err := d.Count(ctx, "items", bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count, "an aborted transaction is rolled back")
+}
+
+// deletedItem is an item implementing the delete hooks
+type deletedItem struct {
+	Id    string `bson:"_id"`
+	Name  string `bson:"name"`
+	trace *[]string
+	veto  error
+}
+
+func (i *deletedItem) BeforeDelete(context.Context) error {
+	*i.trace = append(*i.trace, "BeforeDelete "+i.Name)
+	return i.veto
+}
+
+func (i *deletedItem) AfterDelete(context.Context) error {
+	*i.trace = append(*i.trace, "AfterDelete "+i.Name)
+	return nil
+}
+
+func TestMemory_FindOneAndDelete_DeleteHooks(t *testing.T) {
+	ctx := context.Background()
+	d := seed(t)
+	d.WithHook(hooks.NewDefaultHook(slog.Default(), nil).WithStore(NewAuditStore(d, "audit_logs", "audit_logs_meta")))
+
+	// BeforeDelete sees the doc it deletes and vetoes its delete
+	var trace []string
+	denied := errors.New("denied")
+	err := d.FindOneAndDelete(ctx, "items", bson.M{"_id": "a"}, &deletedItem{trace: &trace, veto: denied})
+	assert.ErrorIs(t, err, db.ErrAborted)
+	assert.ErrorIs(t, err, denied)
+	assert.Equal(t, []string{"BeforeDelete apple"}, trace)
+	count, err := d.Count(ctx, "items", bson.M{"_id": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	trace = nil
+	require.NoError(t, d.FindOneAndDelete(ctx, "items", bson.M{"_id": "a"}, &deletedItem{trace: &trace}))
+	assert.Equal(t, []string{"BeforeDelete apple", "AfterDelete apple"}, trace)
+	count, err = d.Count(ctx, "items", bson.M{"_id": "a"})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.ErrorIs(t, d.FindOneAndDelete(ctx, "items", bson.M{"_id": "a"}, &deletedItem{trace: &trace}), db.ErrNotFound)
+}
+
+// audited returns a Memory auditing its writes into audit collections it holds itself
+func audited(softDelete ...string) *Memory {
+	d := InitMemory(nil).WithSoftDelete(softDelete...)
+	return d.WithHook(hooks.NewDefaultHook(slog.Default(), nil).WithStore(NewAuditStore(d, "audit_logs", "audit_logs_meta")))
+}
+
+// auditLogs returns the audit logs of document id of collection col, oldest first
+func auditLogs(t *testing.T, d *Memory, col, id string) []in.AuditLog {
+	t.Helper()
+	var logs []in.AuditLog
+	require.NoError(t, d.List(context.Background(), "audit_logs", bson.M{"collection": col, "document_id": id}, 0, 0, &logs, bson.M{"_id": 1}))
+	return logs
+}
+
+type user struct {
+	in.Inject
+	ID   primitive.ObjectID `bson:"_id"`
+	Name string             `bson:"name"`
+	Age  int                `bson:"age"`
+}
+
+func TestMemory_PartialUpdate(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryuser")
+	ctx := context.Background()
+	d := audited()
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu", Age: 30}
+	require.NoError(t, d.Insert(ctx, "user", u))
+
+	require.NoError(t, d.Update(ctx, "user", bson.M{"_id": u.ID}, bson.M{"name": "Razibul"}))
+	require.NoError(t, d.Update(ctx, "user", bson.M{"_id": u.ID}, bson.D{{Key: "age", Value: 31}}))
+	logs := auditLogs(t, d, "user", u.ID.Hex())
+	require.Len(t, logs, 3)
+	assert.Equal(t, map[string]in.AuditChange{"name": {Old: "Mithu", New: "Razibul"}}, logs[1].Change,
+		"fields left out of the update are unchanged")
+	assert.Equal(t, map[string]in.AuditChange{"age": {Old: "30", New: "31"}}, logs[2].Change)
+
+	// Documents of collections that aren't audited are skipped
+	session := primitive.NewObjectID()
+	require.NoError(t, d.InsertMany(ctx, "session", []interface{}{bson.M{"_id": session, "token": "a"}}))
+	require.NoError(t, d.Update(ctx, "session", bson.M{"_id": session}, bson.M{"token": "b"}))
+	assert.Empty(t, auditLogs(t, d, "session", session.Hex()))
+}
+
+func TestMemory_UpdateStoredImages(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryuser")
+	ctx := context.Background()
+	d := audited()
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu", Age: 30}
+	require.NoError(t, d.Insert(ctx, "user", u))
+
+	// A change made outside the audited writes shows up in the next audited diff
+	require.NoError(t, d.PartialUpdateMany(ctx, "user", bson.M{"_id": u.ID}, bson.M{"age": 40}))
+	require.NoError(t, d.Update(ctx, "user", bson.M{"_id": u.ID}, bson.M{"name": "Razibul"}))
+	logs := auditLogs(t, d, "user", u.ID.Hex())
+	require.Len(t, logs, 2)
+	assert.Equal(t, map[string]in.AuditChange{
+		"name": {Old: "Mithu", New: "Razibul"},
+		"age":  {Old: "30", New: "40"},
+	}, logs[1].Change)
+}
+
+type profile struct {
+	in.Inject
+	ID      string    `bson:"_id"`
+	Born    time.Time `bson:"born"`
+	Address struct {
+		City string `bson:"city"`
+	} `bson:"address"`
+	Bio string `bson:"bio"`
+}
+
+func TestMemory_UpdateComparesStoredTypes(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryprofile")
+	ctx := context.Background()
+	d := audited()
+	// Nanoseconds and a zone aren't stored, the diff mustn't report them
+	p := &profile{ID: "p1", Born: time.Date(1990, 1, 2, 3, 4, 5, 678901234, time.FixedZone("BST", 6*3600)), Bio: "hi"}
+	p.Address.City = "Dhaka"
+	require.NoError(t, d.Insert(ctx, "profile", p))
+
+	p.Bio = "hello"
+	require.NoError(t, d.Update(ctx, "profile", bson.M{"_id": "p1"}, p))
+	logs := auditLogs(t, d, "profile", "p1")
+	require.Len(t, logs, 2)
+	assert.Equal(t, map[string]in.AuditChange{"bio": {Old: "hi", New: "hello"}}, logs[1].Change)
+}
+
+type ticket struct {
+	in.Inject
+	ID     int64  `bson:"_id"`
+	Status string `bson:"status"`
+}
+
+func TestMemory_NonObjectIDs(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryticket")
+	ctx := context.Background()
+	d := audited()
+	require.NoError(t, d.Insert(ctx, "ticket", &ticket{ID: 7, Status: "new"}))
+	require.NoError(t, d.Update(ctx, "ticket", bson.M{"_id": 7}, bson.M{"status": "paid"}))
+	require.NoError(t, d.DeleteMany(ctx, "ticket", bson.M{"_id": 7}))
+
+	logs := auditLogs(t, d, "ticket", in.DocumentID(int64(7)))
+	require.Len(t, logs, 3)
+	assert.Equal(t, "7", logs[0].DocumentId)
+	assert.Equal(t, map[string]in.AuditChange{"status": {Old: "new", New: "paid"}}, logs[1].Change)
+	assert.Equal(t, "delete", logs[2].Operation)
+	assert.Equal(t, logs[0].AuditMetaId, logs[1].AuditMetaId)
+}
+
+type note struct {
+	in.Inject
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Text string             `bson:"text"`
+}
+
+func TestMemory_InsertWithoutID(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memorynote")
+	ctx := context.Background()
+	d := audited()
+	require.NoError(t, d.Insert(ctx, "note", &note{Text: "first"}))
+	require.NoError(t, d.Insert(ctx, "note", &note{Text: "second"}))
+	var notes []note
+	require.NoError(t, d.List(ctx, "note", bson.M{}, 0, 0, &notes))
+	require.Len(t, notes, 2)
+
+	require.NoError(t, d.DeleteMany(ctx, "note", bson.M{}))
+	var metas []string
+	for _, n := range notes {
+		logs := auditLogs(t, d, "note", n.ID.Hex())
+		require.Len(t, logs, 2, "the generated id is audited")
+		assert.Equal(t, []string{"insert", "delete"}, []string{logs[0].Operation, logs[1].Operation})
+		metas = append(metas, logs[0].AuditMetaId)
+	}
+	assert.NotEqual(t, metas[0], metas[1], "every document has its own meta")
+	assert.Empty(t, auditLogs(t, d, "note", primitive.NilObjectID.Hex()))
+}
+
+func TestMemory_UpsertAndReplace(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryticket")
+	ctx := context.Background()
+	d := audited()
+
+	require.NoError(t, d.Upsert(ctx, "ticket", bson.M{"_id": 8}, &ticket{ID: 8, Status: "new"}))
+	require.NoError(t, d.Upsert(ctx, "ticket", bson.M{"_id": 8}, &ticket{ID: 8, Status: "paid"}))
+	require.NoError(t, d.Replace(ctx, "ticket", bson.M{"_id": 8}, &ticket{ID: 8, Status: "shipped"}))
+	require.NoError(t, d.Replace(ctx, "ticket", bson.M{"_id": 9}, &ticket{ID: 9, Status: "new"}))
+
+	logs := auditLogs(t, d, "ticket", "8")
+	require.Len(t, logs, 3)
+	assert.Equal(t, []string{"insert", "update", "update"}, []string{logs[0].Operation, logs[1].Operation, logs[2].Operation})
+	assert.Equal(t, []string{"upsert", "upsert", "replace"}, []string{logs[0].AuditEvent, logs[1].AuditEvent, logs[2].AuditEvent})
+	assert.Equal(t, map[string]in.AuditChange{"status": {Old: "paid", New: "shipped"}}, logs[2].Change)
+	logs = auditLogs(t, d, "ticket", "9")
+	require.Len(t, logs, 1)
+	assert.Equal(t, in.AuditChange{Old: "<nil>", New: "new"}, logs[0].Change["status"], "a replace inserting its doc creates its baseline")
+
+	// An upserted partial document isn't audited, the collection may not be
+	require.NoError(t, d.Upsert(ctx, "ticket", bson.M{"_id": 10}, bson.M{"status": "new"}))
+	assert.Empty(t, auditLogs(t, d, "ticket", "10"))
+}
+
+func TestMemory_SoftDelete(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryuser")
+	ctx := context.Background()
+	d := audited("user")
+	u := &user{ID: primitive.NewObjectID(), Name: "Mithu", Age: 30}
+	require.NoError(t, d.Insert(ctx, "user", u))
+
+	var deleted user
+	require.NoError(t, d.FindOneAndDelete(ctx, "user", bson.M{"_id": u.ID}, &deleted))
+	assert.Equal(t, "Mithu", deleted.Name)
+	assert.ErrorIs(t, d.FindOne(ctx, "user", bson.M{"_id": u.ID}, &user{}), db.ErrNotFound)
+	count, err := d.Count(ctx, "user", nil)
+	require.NoError(t, err)
+	assert.Zero(t, count, "soft-deleted docs aren't counted")
+	var users []user
+	require.NoError(t, d.List(ctx, "user", bson.M{}, 0, 0, &users))
+	assert.Empty(t, users)
+	require.NoError(t, d.List(db.WithDeleted(ctx), "user", bson.M{}, 0, 0, &users))
+	assert.Len(t, users, 1, "db.WithDeleted includes soft-deleted docs")
+	assert.ErrorIs(t, d.FindOneAndDelete(ctx, "user", bson.M{"_id": u.ID}, &deleted), db.ErrNotFound,
+		"a soft-deleted doc isn't deleted again")
+
+	require.NoError(t, d.Restore(ctx, "user", bson.M{"_id": u.ID}))
+	require.NoError(t, d.FindOne(ctx, "user", bson.M{"_id": u.ID}, &user{}))
+
+	logs := auditLogs(t, d, "user", u.ID.Hex())
+	require.Len(t, logs, 3)
+	assert.Equal(t, []string{"insert", "soft_delete", "restore"}, []string{logs[0].AuditEvent, logs[1].AuditEvent, logs[2].AuditEvent})
+	assert.Equal(t, "update", logs[1].Operation)
+	assert.Equal(t, "<nil>", logs[1].Change[db.DeletedAt].Old)
+	assert.NotEmpty(t, logs[1].Change[db.DeletedAt].New)
+	assert.Equal(t, map[string]in.AuditChange{db.DeletedAt: {Old: logs[1].Change[db.DeletedAt].New}}, logs[2].Change)
+}
+
+func TestMemory_DeleteOne(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryticket")
+	ctx := context.Background()
+	d := audited()
+	require.NoError(t, d.Insert(ctx, "ticket", &ticket{ID: 11, Status: "new"}))
+	require.NoError(t, d.Insert(ctx, "ticket", &ticket{ID: 12, Status: "new"}))
+
+	require.NoError(t, d.DeleteOne(ctx, "ticket", bson.M{"status": "new"}))
+	count, err := d.Count(ctx, "ticket", bson.M{"status": "new"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "DeleteOne deletes a single doc")
+	require.NoError(t, d.DeleteOne(ctx, "ticket", bson.M{"_id": 99}), "no match isn't an error")
+
+	var deleted ticket
+	require.NoError(t, d.FindOneAndDelete(ctx, "ticket", bson.M{"status": "new"}, &deleted))
+	assert.Equal(t, int64(12), deleted.ID)
+	assert.ErrorIs(t, d.FindOneAndDelete(ctx, "ticket", bson.M{"status": "new"}, &deleted), db.ErrNotFound)
+
+	for _, id := range []string{"11", "12"} {
+		logs := auditLogs(t, d, "ticket", id)
+		require.Len(t, logs, 2)
+		assert.Equal(t, "delete", logs[1].Operation)
+	}
+}
+
+type account struct {
+	in.Inject
+	ID      string `bson:"_id"`
+	Balance int    `bson:"balance"`
+	Version int64  `bson:"version" gaudit:"version"`
+}
+
+func TestMemory_VersionConflict(t *testing.T) {
+	audit.RegisterModel("github.com/its-own/gaudit/internal/infracture/db/memoryaccount")
+	ctx := context.Background()
+	d := audited()
+	require.NoError(t, d.Insert(ctx, "account", &account{ID: "a1", Balance: 10}))
+
+	first := &account{ID: "a1", Balance: 20}
+	second := &account{ID: "a1", Balance: 30}
+	require.NoError(t, d.Update(ctx, "account", bson.M{"_id": "a1"}, first))
+	assert.Equal(t, int64(1), first.Version, "the version is incremented in the model")
+
+	err := d.Update(ctx, "account", bson.M{"_id": "a1"}, second)
+	assert.ErrorIs(t, err, db.ErrConflict, "second was read at version 0")
+	var conflict *db.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, int64(0), conflict.Version)
+	assert.ErrorIs(t, d.Update(ctx, "account", bson.M{"_id": "a2"}, second), db.ErrNotFound)
+
+	first.Balance = 25
+	require.NoError(t, d.Update(ctx, "account", bson.M{"_id": "a1"}, first))
+	var stored account
+	require.NoError(t, d.FindOne(ctx, "account", bson.M{"_id": "a1"}, &stored))
+	assert.Equal(t, account{ID: "a1", Balance: 25, Version: 2}, stored)
+
+	logs := auditLogs(t, d, "account", "a1")
+	require.Len(t, logs, 3, "the conflicting update isn't audited")
+	var versions []int64
+	for _, log := range logs {
+		require.NotNil(t, log.Version)
+		versions = append(versions, *log.Version)
+	}
+	assert.Equal(t, []int64{0, 1, 2}, versions)
+	assert.Equal(t, in.AuditChange{Old: "20", New: "25"}, logs[2].Change["balance"])
+
+	// A model passed by value isn't incremented, its audit log has the stored version
+	require.NoError(t, d.Update(ctx, "account", bson.M{"_id": "a1"}, account{ID: "a1", Balance: 40, Version: 2}))
+	logs = auditLogs(t, d, "account", "a1")
+	require.Len(t, logs, 4)
+	require.NotNil(t, logs[3].Version)
+	assert.Equal(t, int64(3), *logs[3].Version)
+}
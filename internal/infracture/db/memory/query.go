@@ -0,0 +1,481 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupported is returned for the query and update operators the memory database doesn't implement
+var ErrUnsupported = errors.New("memory: unsupported operator")
+
+// toDoc converts v to a document the way the driver would store it, so documents, filters
+// and updates compare with the same types as in MongoDB
+func toDoc(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// toOrderedDoc is toDoc keeping the order of the keys, for sorts
+func toOrderedDoc(v interface{}) (bson.D, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// asMap returns v as a map when it is an embedded document
+func asMap(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return d, true
+	case bson.D:
+		m := make(bson.M, len(d))
+		for _, e := range d {
+			m[e.Key] = e.Value
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// lookup returns the value at the dotted path of doc
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := asMap(current)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// set sets the value at the dotted path of doc, creating the missing embedded documents
+func set(doc bson.M, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := asMap(doc[key])
+		if !ok {
+			next = bson.M{}
+		}
+		doc[key] = next
+		doc = next
+	}
+	doc[keys[len(keys)-1]] = value
+}
+
+// unset removes the value at the dotted path of doc
+func unset(doc bson.M, path string) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := asMap(doc[key])
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, keys[len(keys)-1])
+}
+
+// match reports whether doc matches filter, a document converted with toDoc
+func match(doc bson.M, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var (
+			ok  bool
+			err error
+		)
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("%w: %s", ErrUnsupported, key)
+			}
+			value, exists := lookup(doc, key)
+			ok, err = matchCondition(value, exists, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	filters, ok := cond.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("memory: %s takes an array", op)
+	}
+	matched := 0
+	for _, f := range filters {
+		filter, ok := asMap(f)
+		if !ok {
+			return false, fmt.Errorf("memory: %s takes an array of documents", op)
+		}
+		ok, err := match(doc, filter)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	switch op {
+	case "$and":
+		return matched == len(filters), nil
+	case "$or":
+		return matched > 0, nil
+	}
+	return matched == 0, nil
+}
+
+// matchCondition reports whether a value satisfies cond, an operator document or a value
+func matchCondition(value interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, ok := asMap(cond)
+	if !ok || !isOperatorDoc(ops) {
+		return matchEqual(value, exists, cond), nil
+	}
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = matchEqual(value, exists, arg)
+		case "$ne":
+			ok = !matchEqual(value, exists, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && compareOp(value, op, arg)
+		case "$in", "$nin":
+			values, isArray := arg.(bson.A)
+			if !isArray {
+				return false, fmt.Errorf("memory: %s takes an array", op)
+			}
+			in := false
+			for _, v := range values {
+				if (v == nil && !exists) || (exists && equals(value, v)) {
+					in = true
+					break
+				}
+			}
+			ok = in == (op == "$in")
+		case "$exists":
+			ok = exists == truthy(arg)
+		case "$not":
+			matched, err := matchCondition(value, exists, arg)
+			if err != nil {
+				return false, err
+			}
+			ok = !matched
+		default:
+			return false, fmt.Errorf("%w: %s", ErrUnsupported, op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEqual reports whether value equals want, a missing value equals nil like in MongoDB
+func matchEqual(value interface{}, exists bool, want interface{}) bool {
+	if want == nil {
+		return !exists || value == nil
+	}
+	return exists && equals(value, want)
+}
+
+func isOperatorDoc(doc bson.M) bool {
+	if len(doc) == 0 {
+		return false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	}
+	if n, ok := number(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+// equals reports whether value equals want, an array value equals any of its elements
+func equals(value, want interface{}) bool {
+	if c, ok := compare(value, want); ok && c == 0 {
+		return true
+	}
+	if values, ok := value.(bson.A); ok {
+		for _, v := range values {
+			if c, ok := compare(v, want); ok && c == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compareOp(value interface{}, op string, arg interface{}) bool {
+	c, ok := compare(value, arg)
+	if !ok {
+		return false
+	}
+	switch op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	}
+	return c <= 0
+}
+
+// compare orders two values of the same kind, it reports false for values of different kinds
+func compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		if !ok || x == y {
+			return 0, ok
+		}
+		if !x {
+			return -1, true
+		}
+		return 1, true
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), ok
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case bson.A:
+		y, ok := b.(bson.A)
+		if !ok {
+			return 0, false
+		}
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c, ok := compare(x[i], y[i]); !ok || c != 0 {
+				return c, ok
+			}
+		}
+		return len(x) - len(y), true
+	}
+	if x, ok := asMap(a); ok {
+		y, ok := asMap(b)
+		if !ok || len(x) != len(y) {
+			return 0, false
+		}
+		for key, v := range x {
+			if c, ok := compare(v, y[key]); !ok || c != 0 {
+				return 1, false
+			}
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// add adds two numbers like $inc, keeping the integer type unless one is a double. A missing
+// value counts as 0.
+func add(a, b interface{}) (interface{}, bool) {
+	if a == nil {
+		a = int32(0)
+	}
+	x, okA := number(a)
+	y, okB := number(b)
+	if !okA || !okB {
+		return nil, false
+	}
+	_, floatA := a.(float64)
+	_, floatB := b.(float64)
+	_, int32A := a.(int32)
+	_, int32B := b.(int32)
+	switch {
+	case floatA || floatB:
+		return x + y, true
+	case int32A && int32B:
+		return int32(x + y), true
+	}
+	return int64(x + y), true
+}
+
+// applyUpdate applies update, a document converted with toDoc, to doc. An update without
+// operators replaces doc but its _id.
+func applyUpdate(doc bson.M, update bson.M) error {
+	if !isOperatorDoc(update) {
+		id := doc["_id"]
+		for key := range doc {
+			delete(doc, key)
+		}
+		for key, value := range update {
+			doc[key] = value
+		}
+		if id != nil {
+			doc["_id"] = id
+		}
+		return nil
+	}
+	for op, arg := range update {
+		fields, ok := asMap(arg)
+		if !ok {
+			return fmt.Errorf("memory: %s takes a document", op)
+		}
+		for path, value := range fields {
+			switch op {
+			case "$set":
+				set(doc, path, value)
+			case "$unset":
+				unset(doc, path)
+			case "$inc":
+				current, _ := lookup(doc, path)
+				sum, ok := add(current, value)
+				if !ok {
+					return fmt.Errorf("memory: $inc takes numbers")
+				}
+				set(doc, path, sum)
+			case "$setOnInsert":
+			default:
+				return fmt.Errorf("%w: %s", ErrUnsupported, op)
+			}
+		}
+	}
+	return nil
+}
+
+// sortDocs sorts docs by spec, a sort document such as bson.D{{Key: "name", Value: 1}}
+func sortDocs(docs []bson.M, spec interface{}) error {
+	if spec == nil {
+		return nil
+	}
+	keys, err := toOrderedDoc(spec)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			a, _ := lookup(docs[i], key.Key)
+			b, _ := lookup(docs[j], key.Key)
+			c := order(a, b)
+			if c == 0 {
+				continue
+			}
+			if n, _ := number(key.Value); n < 0 {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// order compares any two values, values of different kinds are ordered by kind like MongoDB does
+func order(a, b interface{}) int {
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	return kindRank(a) - kindRank(b)
+}
+
+func kindRank(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if _, ok := number(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case string:
+		return 2
+	case bson.A:
+		return 4
+	case primitive.ObjectID:
+		return 5
+	case bool:
+		return 6
+	case primitive.DateTime:
+		return 7
+	}
+	if _, ok := asMap(v); ok {
+		return 3
+	}
+	return 8
+}
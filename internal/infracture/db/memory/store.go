@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/internal/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditStore keeps the audit trail in two collections of a Memory, it implements hooks.AuditStore
+type AuditStore struct {
+	d     *Memory
+	logs  string
+	metas string
+}
+
+// NewAuditStore returns the AuditStore writing the audit logs to collection logs of d and
+// their metas to collection metas
+func NewAuditStore(d *Memory, logs, metas string) *AuditStore {
+	return &AuditStore{d: d, logs: logs, metas: metas}
+}
+
+func (s *AuditStore) FindMetas(ctx context.Context, docIds []string) ([]entities.AuditLogMeta, error) {
+	var metas []entities.AuditLogMeta
+	filter := bson.M{"document_current_state._id": bson.M{"$in": docIds}}
+	if err := s.d.List(ctx, s.metas, filter, 0, 0, &metas); err != nil {
+		return nil, fmt.Errorf("error finding audit log meta: %w", err)
+	}
+	return metas, nil
+}
+
+func (s *AuditStore) WriteMetas(ctx context.Context, writes []mongo.WriteModel) error {
+	if err := s.d.BulkUpdate(ctx, s.metas, writes); err != nil {
+		return fmt.Errorf("error writing audit log meta: %w", err)
+	}
+	return nil
+}
+
+func (s *AuditStore) InsertLogs(ctx context.Context, logs []entities.AuditLog) error {
+	for _, log := range logs {
+		// Logs inserted by an earlier attempt of the same write are skipped
+		if _, err := s.d.insert(s.logs, log); err != nil && !errors.Is(err, db.ErrDuplicateKey) {
+			return fmt.Errorf("error inserting audit log: %w", err)
+		}
+	}
+	return nil
+}
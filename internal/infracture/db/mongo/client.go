@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/its-own/gaudit/db"
 	in "github.com/its-own/gaudit/in"
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,39 +12,79 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"time"
 )
 
 // Mongo holds necessary fields and mongo Database session to connect
 type Mongo struct {
 	*mongo.Client
 	Database *mongo.Database
-	hook     in.Hook
+	hook     in.HookV2
+	opts     Options
 }
 
-var instance *Mongo
+// Options tunes how writes are audited
+type Options struct {
+	// Transactional runs every audited write and its audit logs in one transaction
+	Transactional bool
+	// SoftDelete lists the collections whose deletes set the deleted_at field of the docs
+	// instead of removing them, FindOne, List and Count skip the soft-deleted docs
+	SoftDelete []string
+}
+
+// flusher and closer are implemented by hooks that write audit logs in the background
+type flusher interface {
+	Flush(ctx context.Context) error
+}
 
-func InitMongo(cl *mongo.Client, database *mongo.Database, hook in.Hook) db.NoSql {
-	instance = &Mongo{
+type closer interface {
+	Close(ctx context.Context) error
+}
+
+// txHook is implemented by hooks that hold back side effects until a transaction commits
+type txHook interface {
+	BeginTx(ctx context.Context) context.Context
+	CommitTx(ctx context.Context)
+}
+
+// InitMongo wraps database with hook, every returned instance is independent of the others
+func InitMongo(cl *mongo.Client, database *mongo.Database, hook in.HookV2, opts Options) db.NoSql {
+	return &Mongo{
 		Client:   cl,
 		Database: database,
 		hook:     hook,
+		opts:     opts,
 	}
-	return instance
-}
-func GetDbConnection() *Mongo {
-	return instance
 }
 
-func (d *Mongo) Ping(ctx context.Context) error {
+func (d *Mongo) Ping(ctx context.Context) (err error) {
+	defer translate(&err, "", "ping")
 	return d.Client.Ping(ctx, readpref.Primary())
 }
 
-func (d *Mongo) Disconnect(ctx context.Context) error {
+// Disconnect writes the pending audit logs before closing the connection
+func (d *Mongo) Disconnect(ctx context.Context) (err error) {
+	defer translate(&err, "", "disconnect")
+	if c, ok := d.hook.(closer); ok {
+		if err := c.Close(ctx); err != nil {
+			return err
+		}
+	}
 	return d.Client.Disconnect(ctx)
 }
 
+// Flush waits until every pending audit log is written
+func (d *Mongo) Flush(ctx context.Context) (err error) {
+	defer translate(&err, "", "flush")
+	if f, ok := d.hook.(flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
 // EnsureIndices creates indices for collection col
-func (d *Mongo) EnsureIndices(ctx context.Context, col string, index []db.Index) error {
+func (d *Mongo) EnsureIndices(ctx context.Context, col string, index []db.Index) (err error) {
+	defer translate(&err, col, "ensure_indices")
 	_db := d.Database
 	var indexModels []mongo.IndexModel
 	for _, ind := range index {
@@ -77,7 +118,8 @@ func (d *Mongo) EnsureIndices(ctx context.Context, col string, index []db.Index)
 }
 
 // DropIndices drops indices from collection col
-func (d *Mongo) DropIndices(ctx context.Context, col string, index []db.Index) error {
+func (d *Mongo) DropIndices(ctx context.Context, col string, index []db.Index) (err error) {
+	defer translate(&err, col, "drop_indices")
 	if _, err := d.Database.Collection(col).Indexes().DropAll(ctx); err != nil {
 		return err
 	}
@@ -85,20 +127,23 @@ func (d *Mongo) DropIndices(ctx context.Context, col string, index []db.Index) e
 }
 
 // Insert inserts doc into collection
-func (d *Mongo) Insert(ctx context.Context, col string, doc interface{}) error {
-	var (
-		err    error
-		insRes *mongo.InsertOneResult
-	)
-	d.hook.PreSave(ctx, doc, nil, col, "insert", "")
-	if insRes, err = d.Database.Collection(col).InsertOne(ctx, doc); err != nil {
+func (d *Mongo) Insert(ctx context.Context, col string, doc interface{}) (err error) {
+	defer translate(&err, col, "insert")
+	if err := d.preSave(ctx, doc, nil, col, "insert"); err != nil {
 		return err
 	}
-	d.hook.PostSave(ctx, doc, nil, col, "insert", insRes.InsertedID.(primitive.ObjectID).Hex())
-	return nil
+	return d.audited(ctx, func(ctx context.Context) error {
+		insRes, err := d.Database.Collection(col).InsertOne(ctx, doc)
+		if err != nil {
+			return err
+		}
+		ctx = withWriteTime(ctx, primitive.Timestamp{})
+		return d.postSave(ctx, doc, nil, col, "insert", in.DocumentID(insRes.InsertedID))
+	})
 }
 
-func (d *Mongo) InsertMany(ctx context.Context, col string, docs []interface{}) error {
+func (d *Mongo) InsertMany(ctx context.Context, col string, docs []interface{}) (err error) {
+	defer translate(&err, col, "insert_many")
 	if _, err := d.Database.Collection(col).InsertMany(ctx, docs); err != nil {
 		return err
 	}
@@ -106,13 +151,14 @@ func (d *Mongo) InsertMany(ctx context.Context, col string, docs []interface{})
 }
 
 // FindOne finds a doc by query
-func (d *Mongo) FindOne(ctx context.Context, col string, q interface{}, v interface{}, sort ...interface{}) error {
+func (d *Mongo) FindOne(ctx context.Context, col string, q interface{}, v interface{}, sort ...interface{}) (err error) {
+	defer translate(&err, col, "find_one")
 	findOneOpts := options.FindOne()
 	if len(sort) > 0 {
 		findOneOpts = findOneOpts.SetSort(sort[0])
 	}
 
-	err := d.Database.Collection(col).FindOne(ctx, q, findOneOpts).Decode(v)
+	err = d.Database.Collection(col).FindOne(ctx, d.readFilter(ctx, col, q), findOneOpts).Decode(v)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return db.ErrNotFound
@@ -123,12 +169,13 @@ func (d *Mongo) FindOne(ctx context.Context, col string, q interface{}, v interf
 }
 
 // List finds list of docs that matches query with skip and limit
-func (d *Mongo) List(ctx context.Context, col string, filter interface{}, skip, limit int64, v interface{}, sort ...interface{}) error {
+func (d *Mongo) List(ctx context.Context, col string, filter interface{}, skip, limit int64, v interface{}, sort ...interface{}) (err error) {
+	defer translate(&err, col, "list")
 	findOpts := options.Find().SetSkip(skip).SetLimit(limit)
 	if len(sort) > 0 {
 		findOpts = findOpts.SetSort(sort[0])
 	}
-	cursor, err := d.Database.Collection(col).Find(ctx, filter, findOpts)
+	cursor, err := d.Database.Collection(col).Find(ctx, d.readFilter(ctx, col, filter), findOpts)
 	if err != nil {
 		return err
 	}
@@ -140,7 +187,8 @@ func (d *Mongo) List(ctx context.Context, col string, filter interface{}, skip,
 }
 
 // Aggregate runs aggregation q on docs and store the result on v
-func (d *Mongo) Aggregate(ctx context.Context, col string, q []interface{}, v interface{}) error {
+func (d *Mongo) Aggregate(ctx context.Context, col string, q []interface{}, v interface{}) (err error) {
+	defer translate(&err, col, "aggregate")
 	cursor, err := d.Database.Collection(col).Aggregate(ctx, q)
 	if err != nil {
 		return err
@@ -151,7 +199,8 @@ func (d *Mongo) Aggregate(ctx context.Context, col string, q []interface{}, v in
 	return nil
 }
 
-func (d *Mongo) AggregateWithDiskUse(ctx context.Context, col string, q []interface{}, v interface{}) error {
+func (d *Mongo) AggregateWithDiskUse(ctx context.Context, col string, q []interface{}, v interface{}) (err error) {
+	defer translate(&err, col, "aggregate")
 	opt := options.Aggregate().SetAllowDiskUse(true)
 	cursor, err := d.Database.Collection(col).Aggregate(ctx, q, opt)
 	if err != nil {
@@ -163,7 +212,8 @@ func (d *Mongo) AggregateWithDiskUse(ctx context.Context, col string, q []interf
 	return nil
 }
 
-func (d *Mongo) Distinct(ctx context.Context, col, field string, q interface{}, v interface{}) error {
+func (d *Mongo) Distinct(ctx context.Context, col, field string, q interface{}, v interface{}) (err error) {
+	defer translate(&err, col, "distinct")
 	interfaces, err := d.Database.Collection(col).Distinct(ctx, field, q)
 	if err != nil {
 		return err
@@ -175,58 +225,561 @@ func (d *Mongo) Distinct(ctx context.Context, col, field string, q interface{},
 	return json.Unmarshal(data, v)
 }
 
-func (d *Mongo) PartialUpdateMany(ctx context.Context, col string, filter interface{}, data interface{}) error {
-	_, err := d.Database.Collection(col).UpdateMany(ctx, filter, bson.M{"$set": data})
+func (d *Mongo) PartialUpdateMany(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer translate(&err, col, "update_many")
+	_, err = d.Database.Collection(col).UpdateMany(ctx, filter, bson.M{"$set": data})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (d *Mongo) PartialUpdateManyByQuery(ctx context.Context, col string, filter interface{}, query db.UnorderedDbQuery) error {
-	_, err := d.Database.Collection(col).UpdateMany(ctx, filter, query)
+func (d *Mongo) PartialUpdateManyByQuery(ctx context.Context, col string, filter interface{}, query db.UnorderedDbQuery) (err error) {
+	defer translate(&err, col, "update_many")
+	_, err = d.Database.Collection(col).UpdateMany(ctx, filter, query)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (d *Mongo) BulkUpdate(ctx context.Context, col string, models []mongo.WriteModel) error {
-	_, err := d.Database.Collection(col).BulkWrite(ctx, models)
+func (d *Mongo) BulkUpdate(ctx context.Context, col string, models []mongo.WriteModel) (err error) {
+	defer translate(&err, col, "bulk_write")
+	_, err = d.Database.Collection(col).BulkWrite(ctx, models)
 	return err
 }
 
-func (d *Mongo) DeleteMany(ctx context.Context, col string, filter interface{}) error {
-	_, err := d.Database.Collection(col).DeleteMany(ctx, filter)
+// DeleteMany deletes the docs matching filter, the deletes of audited docs are audited. In a
+// soft-delete collection the docs are soft-deleted instead.
+func (d *Mongo) DeleteMany(ctx context.Context, col string, filter interface{}) (err error) {
+	defer translate(&err, col, "delete")
+	if d.isSoftDelete(col) {
+		_, err := d.setDeleted(ctx, col, filter, true, "soft_delete")
+		return err
+	}
+	if err := d.preSave(ctx, nil, filter, col, "delete"); err != nil {
+		return err
+	}
+	return d.audited(ctx, func(ctx context.Context) error {
+		// Ids are read first, the deleted docs can't be found afterwards. The docs are deleted
+		// by id, so a doc matching filter in between is neither deleted nor audited.
+		cursor, err := d.Database.Collection(col).Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		var docs []bson.M
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		read := operationTime(ctx)
+		if _, err := d.Database.Collection(col).DeleteMany(ctx, byIds(docs)); err != nil {
+			return err
+		}
+		ctx = withWriteTime(ctx, read)
+		for _, doc := range docs {
+			if err := d.postSave(ctx, nil, filter, col, "delete", in.DocumentID(doc["_id"])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteOne deletes the first doc matching filter, its delete is audited if the doc is audited.
+// In a soft-delete collection the doc is soft-deleted instead.
+func (d *Mongo) DeleteOne(ctx context.Context, col string, filter interface{}) (err error) {
+	defer translate(&err, col, "delete")
+	if err := d.findOneAndDelete(ctx, col, filter, nil); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// FindOneAndDelete deletes the first doc matching filter like DeleteOne and decodes it into v,
+// it returns db.ErrNotFound when no doc matches. PreSave receives v decoded from the doc before
+// it is deleted, so BeforeDelete can veto the delete, and PostSave receives v as the model and
+// the deleted doc as the before image.
+func (d *Mongo) FindOneAndDelete(ctx context.Context, col string, filter interface{}, v interface{}) (err error) {
+	defer translate(&err, col, "delete")
+	return d.findOneAndDelete(ctx, col, filter, v)
+}
+
+func (d *Mongo) findOneAndDelete(ctx context.Context, col string, filter interface{}, v interface{}) error {
+	if d.isSoftDelete(col) {
+		docs, err := d.setDeleted(ctx, col, filter, false, "soft_delete")
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return db.ErrNotFound
+		}
+		return decode(docs[0], v)
+	}
+	if v == nil {
+		if err := d.preSave(ctx, nil, filter, col, "delete"); err != nil {
+			return err
+		}
+	}
+	return d.audited(ctx, func(ctx context.Context) error {
+		query := filter
+		if v != nil {
+			// The doc is read first, so the PreSave hooks of v, BeforeDelete included, see the doc
+			// they delete. It is deleted by _id, db.ErrNotFound is returned if it is gone by then.
+			var doc bson.M
+			if err := d.Database.Collection(col).FindOne(ctx, filter).Decode(&doc); err != nil {
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return db.ErrNotFound
+				}
+				return err
+			}
+			if err := decode(doc, v); err != nil {
+				return err
+			}
+			if err := d.preSave(ctx, v, filter, col, "delete"); err != nil {
+				return err
+			}
+			query = bson.M{"_id": doc["_id"]}
+		}
+		var doc bson.M
+		if err := d.Database.Collection(col).FindOneAndDelete(ctx, query).Decode(&doc); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return db.ErrNotFound
+			}
+			return err
+		}
+		ctx = withWriteTime(ctx, primitive.Timestamp{})
+		if err := decode(doc, v); err != nil {
+			return err
+		}
+		ctx = in.WithImages(ctx, in.Images{Before: doc})
+		return d.postSave(ctx, v, filter, col, "delete", in.DocumentID(doc["_id"]))
+	})
+}
+
+// Restore undoes the soft delete of the docs matching filter, the restores of audited docs are audited
+func (d *Mongo) Restore(ctx context.Context, col string, filter interface{}) (err error) {
+	defer translate(&err, col, "restore")
+	_, err = d.setDeleted(ctx, col, filter, true, "restore")
 	return err
 }
 
-func (d *Mongo) Count(ctx context.Context, col string, q interface{}) (int64, error) {
-	cnt, err := d.Database.Collection(col).CountDocuments(ctx, q)
+// setDeleted soft-deletes the first or every doc matching filter that isn't soft-deleted, or
+// restores the soft-deleted ones when ops is "restore". It returns the docs as they were before.
+// PostSave receives each doc before and after the write.
+func (d *Mongo) setDeleted(ctx context.Context, col string, filter interface{}, many bool, ops string) ([]bson.M, error) {
+	if err := d.preSave(ctx, nil, filter, col, ops); err != nil {
+		return nil, err
+	}
+	deletedAt := primitive.NewDateTimeFromTime(time.Now())
+	state, update := softDeleteUpdate(ops, deletedAt)
+	var docs []bson.M
+	err := d.audited(ctx, func(ctx context.Context) error {
+		// The docs are read first, the ones already in the target state are left untouched
+		query := interface{}(state)
+		if filter != nil {
+			query = bson.M{"$and": bson.A{filter, state}}
+		}
+		findOpts := options.Find()
+		if !many {
+			findOpts.SetLimit(1)
+		}
+		cursor, err := d.Database.Collection(col).Find(ctx, query, findOpts)
+		if err != nil {
+			return err
+		}
+		docs = nil
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		read := operationTime(ctx)
+		_, err = d.Database.Collection(col).UpdateMany(ctx, bson.M{"$and": bson.A{byIds(docs), state}}, update)
+		if err != nil {
+			return err
+		}
+		ctx = withWriteTime(ctx, read)
+		for _, doc := range docs {
+			after := make(bson.M, len(doc)+1)
+			for key, value := range doc {
+				after[key] = value
+			}
+			if ops == "restore" {
+				delete(after, db.DeletedAt)
+			} else {
+				after[db.DeletedAt] = deletedAt
+			}
+			ctx := in.WithImages(ctx, in.Images{Before: doc, After: after})
+			if err := d.postSave(ctx, nil, filter, col, ops, in.DocumentID(doc["_id"])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// softDeleteUpdate returns the state of the docs a soft delete applies to and its update, setting
+// deleted_at to deletedAt, or those of a restore, unsetting it, when ops is "restore"
+func softDeleteUpdate(ops string, deletedAt primitive.DateTime) (bson.M, bson.M) {
+	if ops == "restore" {
+		return bson.M{db.DeletedAt: bson.M{"$ne": nil}}, bson.M{"$unset": bson.M{db.DeletedAt: ""}}
+	}
+	return bson.M{db.DeletedAt: nil}, bson.M{"$set": bson.M{db.DeletedAt: deletedAt}}
+}
+
+// byIds returns the filter matching docs by their _id, whatever its type
+func byIds(docs []bson.M) bson.M {
+	ids := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc["_id"])
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
+
+// isSoftDelete reports whether col is a soft-delete collection
+func (d *Mongo) isSoftDelete(col string) bool {
+	for _, c := range d.opts.SoftDelete {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// readFilter restricts filter to the docs that aren't soft-deleted when col is a soft-delete
+// collection, unless ctx comes from db.WithDeleted
+func (d *Mongo) readFilter(ctx context.Context, col string, filter interface{}) interface{} {
+	if !d.isSoftDelete(col) || db.IncludesDeleted(ctx) {
+		return filter
+	}
+	return db.NotDeleted(filter)
+}
+
+func (d *Mongo) Count(ctx context.Context, col string, q interface{}) (cnt int64, err error) {
+	defer translate(&err, col, "count")
+	return d.Database.Collection(col).CountDocuments(ctx, d.readFilter(ctx, col, q))
+}
+
+// Update sets data on the first doc matching filter. PostSave receives the doc as stored
+// before and after the update, see in.ImagesFrom. When data is a versioned model, see
+// in.VersionTag, only a doc at its version is updated, the version is incremented in the doc
+// and in data, and a *db.ConflictError is returned when the doc is at another version.
+func (d *Mongo) Update(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer translate(&err, col, "update")
+	_, version, versioned := in.VersionOf(data)
+	query, update, err := setUpdate(filter, data)
 	if err != nil {
+		return err
+	}
+	if err = d.preSave(ctx, data, filter, col, "update"); err != nil {
+		return err
+	}
+	return d.audited(ctx, func(ctx context.Context) error {
+		before, after, err := d.updateOne(ctx, col, query, update)
+		if err != nil {
+			if versioned && errors.Is(err, mongo.ErrNoDocuments) {
+				// The version check failed if the doc exists at all
+				if findErr := d.Database.Collection(col).FindOne(ctx, filter).Err(); findErr == nil {
+					return &db.ConflictError{Collection: col, Version: version}
+				}
+			}
+			return err
+		}
+		ctx = withWriteTime(ctx, primitive.Timestamp{})
+		if versioned {
+			// A model passed by value can't be set, its audit log reads the version of the stored doc
+			in.SetVersion(data, version+1)
+		}
+		ctx = in.WithImages(ctx, in.Images{Before: before, After: after})
+		return d.postSave(ctx, data, filter, col, "update", in.DocumentID(before["_id"]))
+	})
+}
+
+// updateOne applies update to the first doc matching query and returns the doc before and after
+// it. The update only applies to the doc as it was read, so the images are those of the update
+// even when the doc is written concurrently: a doc changed in between is read again.
+func (d *Mongo) updateOne(ctx context.Context, col string, query interface{}, update bson.M) (bson.M, bson.M, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	for {
+		raw, err := d.Database.Collection(col).FindOne(ctx, query).Raw()
+		if err != nil {
+			return nil, nil, err
+		}
+		guard, before, err := unchanged(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		var after bson.M
+		err = d.Database.Collection(col).FindOneAndUpdate(ctx, guard, update, opts).Decode(&after)
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return 0, db.ErrNotFound
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
 		}
-		return 0, err
+		return before, after, nil
 	}
-	return cnt, nil
 }
 
-func (d *Mongo) Update(ctx context.Context, col string, filter interface{}, data interface{}) error {
+// unchanged returns the filter matching the stored doc raw as long as it is unchanged, and raw
+// decoded. The doc is compared as a whole, in field order, so it is decoded as a bson.D.
+func unchanged(raw bson.Raw) (bson.M, bson.M, error) {
+	var current bson.D
+	if err := bson.Unmarshal(raw, &current); err != nil {
+		return nil, nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+	guard := bson.M{
+		"_id":   doc["_id"],
+		"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": current}}},
+	}
+	return guard, doc, nil
+}
+
+// setUpdate returns the query and the update of an Update setting data, a model or a partial
+// document, on the doc matching filter. A versioned model is only set on the doc at its
+// version, see versionedUpdate.
+func setUpdate(filter, data interface{}) (interface{}, bson.M, error) {
+	field, version, versioned := in.VersionOf(data)
+	if versioned {
+		return versionedUpdate(filter, data, field, version)
+	}
+	return filter, bson.M{"$set": data}, nil
+}
+
+// versionedUpdate returns the query and the update of an Update of model, a model whose version
+// field is field: the query matches the doc at version, a doc without version being at 0, and
+// the update increments it
+func versionedUpdate(filter, model interface{}, field string, version int64) (interface{}, bson.M, error) {
+	data, err := bson.Marshal(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(data, &set); err != nil {
+		return nil, nil, err
+	}
+	delete(set, field)
+	current := interface{}(version)
+	if version == 0 {
+		current = bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	query := bson.M{field: current}
+	if filter != nil {
+		query = bson.M{"$and": bson.A{filter, query}}
+	}
+	return query, bson.M{"$set": set, "$inc": bson.M{field: 1}}, nil
+}
+
+// Upsert sets data on the first doc matching filter, or inserts a doc made of the equality
+// fields of filter and data when none matches. PostSave receives the doc as stored before and
+// after the write, the before image is nil when the doc was inserted.
+func (d *Mongo) Upsert(ctx context.Context, col string, filter interface{}, data interface{}) (err error) {
+	defer translate(&err, col, "upsert")
+	if err := d.preSave(ctx, data, filter, col, "upsert"); err != nil {
+		return err
+	}
+	return d.audited(ctx, func(ctx context.Context) error {
+		var before bson.M
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetUpsert(true)
+		err := d.Database.Collection(col).FindOneAndUpdate(ctx, filter, bson.M{"$set": data}, opts).Decode(&before)
+		ctx = withWriteTime(ctx, primitive.Timestamp{})
+		return d.postUpsert(ctx, data, filter, col, "upsert", before, err)
+	})
+}
+
+// Replace replaces the first doc matching filter by doc, or inserts doc when none matches.
+// PostSave receives the doc as stored before and after the write, the before image is nil
+// when the doc was inserted.
+func (d *Mongo) Replace(ctx context.Context, col string, filter interface{}, doc interface{}) (err error) {
+	defer translate(&err, col, "replace")
+	if err := d.preSave(ctx, doc, filter, col, "replace"); err != nil {
+		return err
+	}
+	return d.audited(ctx, func(ctx context.Context) error {
+		var before bson.M
+		opts := options.FindOneAndReplace().SetReturnDocument(options.Before).SetUpsert(true)
+		err := d.Database.Collection(col).FindOneAndReplace(ctx, filter, doc, opts).Decode(&before)
+		ctx = withWriteTime(ctx, primitive.Timestamp{})
+		return d.postUpsert(ctx, doc, filter, col, "replace", before, err)
+	})
+}
+
+// postUpsert reads the doc written by an upsert back and runs the PostSave hook, err is the
+// error of the upsert, mongo.ErrNoDocuments when the doc was inserted. An inserted doc is read
+// back by its _id if model sets it, else by filter.
+func (d *Mongo) postUpsert(ctx context.Context, model, filter interface{}, col, ops string, before bson.M, err error) error {
+	inserted := errors.Is(err, mongo.ErrNoDocuments)
+	if err != nil && !inserted {
+		return err
+	}
+	if inserted {
+		before = nil
+	}
+	var after bson.M
+	if err := d.Database.Collection(col).FindOne(ctx, readBackQuery(model, filter, before)).Decode(&after); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: the written doc can't be read back", db.ErrAuditFailed)
+		}
+		return err
+	}
+	ctx = in.WithImages(ctx, in.Images{Before: before, After: after})
+	return d.postSave(ctx, model, filter, col, ops, in.DocumentID(after["_id"]))
+}
+
+// readBackQuery returns the query reading back the doc written by an upsert of model on filter,
+// before being the doc before the write, nil when it was inserted
+func readBackQuery(model, filter interface{}, before bson.M) interface{} {
+	if before != nil {
+		return bson.M{"_id": before["_id"]}
+	}
+	if id, ok := modelID(model); ok {
+		return bson.M{"_id": id}
+	}
+	return filter
+}
+
+// decode decodes doc into v, v may be nil
+func decode(doc bson.M, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// modelID returns the _id set by model, a struct or a document
+func modelID(model interface{}) (interface{}, bool) {
+	data, err := bson.Marshal(model)
+	if err != nil {
+		return nil, false
+	}
+	id, err := bson.Raw(data).LookupErr("_id")
+	if err != nil {
+		return nil, false
+	}
+	return id, true
+}
+
+// audited runs write, which performs a write and its PostSave hook. In transactional
+// mode both run in one transaction, so the write never commits without its audit logs.
+// Otherwise write runs in a session of its own, which tells its cluster time, see withWriteTime.
+func (d *Mongo) audited(ctx context.Context, write func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return write(ctx)
+	}
+	if d.opts.Transactional {
+		return d.WithTransaction(ctx, write)
+	}
+	// A session of the caller is kept, its writes may belong to its transaction
+	if mongo.SessionFromContext(ctx) != nil {
+		return write(ctx)
+	}
+	session, err := d.Client.StartSession(options.Session().SetCausalConsistency(false))
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	sc := mongo.NewSessionContext(ctx, session)
+	return write(context.WithValue(sc, sessionKey{}, session))
+}
+
+type sessionKey struct{}
+
+// operationTime returns the operation time of the session audited runs the write in, zero
+// when the write runs in a transaction, whose writes all take the cluster time of its commit
+func operationTime(ctx context.Context) primitive.Timestamp {
+	session, ok := ctx.Value(sessionKey{}).(mongo.Session)
+	if !ok || session.OperationTime() == nil {
+		return primitive.Timestamp{}
+	}
+	return *session.OperationTime()
+}
+
+// withWriteTime returns ctx carrying the cluster time window of the write just made, see
+// in.WriteTime. read is the operation time of the read preceding a write of several docs,
+// the write comes after it, and zero for the write of a single doc.
+func withWriteTime(ctx context.Context, read primitive.Timestamp) context.Context {
+	to := operationTime(ctx)
+	if to.IsZero() {
+		return ctx
+	}
+	from := to
+	if !read.IsZero() {
+		from = primitive.Timestamp{T: read.T, I: read.I + 1}
+	}
+	return in.WithWriteTime(ctx, in.WriteTime{From: from, To: to})
+}
+
+type txKey struct{}
+
+// inTransaction reports whether ctx belongs to a WithTransaction callback
+func inTransaction(ctx context.Context) bool {
+	return ctx.Value(txKey{}) != nil
+}
+
+// WithTransaction runs fn in a transaction. Every audited write made by fn with the ctx
+// it receives is part of the transaction, and so are their audit logs: they are written
+// if the transaction commits and discarded if it aborts. Calls made with a ctx that is
+// already in a transaction join it. fn may be retried on transient transaction errors.
+// The error of fn is returned as is, the failures of the transaction itself are translated.
+func (d *Mongo) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if inTransaction(ctx) {
+		return fn(ctx)
+	}
+	session, err := d.Client.StartSession()
+	if err != nil {
+		translate(&err, "", "transaction")
+		return err
+	}
+	defer session.EndSession(ctx)
+
 	var (
-		err  error
-		opts = options.FindOneAndUpdate().SetReturnDocument(options.After)
-		res  bson.M
+		txCtx context.Context
+		fnErr error
 	)
-	update := bson.M{
-		"$set": data,
-	}
-	d.hook.PreSave(ctx, data, filter, col, "update", "")
-	if err = d.Database.Collection(col).FindOneAndUpdate(ctx, filter, update, opts).Decode(&res); err != nil {
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// The callback is retried on transient errors, each attempt starts afresh
+		txCtx = context.WithValue(sc, txKey{}, true)
+		if h, ok := d.hook.(txHook); ok {
+			txCtx = h.BeginTx(txCtx)
+		}
+		fnErr = fn(txCtx)
+		return nil, fnErr
+	})
+	if err != nil {
+		if err != fnErr {
+			translate(&err, "", "transaction")
+		}
 		return err
 	}
-	if id, ok := res["_id"].(primitive.ObjectID); ok {
-		d.hook.PostSave(ctx, data, filter, col, "update", id.Hex())
+	if h, ok := d.hook.(txHook); ok {
+		h.CommitTx(txCtx)
+	}
+	return nil
+}
+
+// preSave runs the PreSave hook, an error vetoes the write and is wrapped with db.ErrAborted
+func (d *Mongo) preSave(ctx context.Context, model interface{}, filter interface{}, col, ops string) error {
+	if err := d.hook.PreSave(ctx, model, filter, col, ops, ""); err != nil {
+		return fmt.Errorf("%w: %w", db.ErrAborted, err)
 	}
 	return nil
 }
+
+// postSave runs the PostSave hook, the hook's failure policy decides which failures are returned.
+// Inside a transaction a returned failure aborts it.
+func (d *Mongo) postSave(ctx context.Context, model interface{}, filter interface{}, col, ops, docId string) error {
+	return d.hook.PostSave(ctx, model, filter, col, ops, docId)
+}
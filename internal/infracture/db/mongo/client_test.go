@@ -0,0 +1,164 @@
+package mongo
+
+import (
+	"context"
+	"github.com/its-own/gaudit/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+// nopHook is an in.HookV2 doing nothing
+type nopHook struct{ name string }
+
+func (h *nopHook) PreSave(context.Context, interface{}, interface{}, string, string, string) error {
+	return nil
+}
+
+func (h *nopHook) PostSave(context.Context, interface{}, interface{}, string, string, string) error {
+	return nil
+}
+
+func TestInitMongo_IndependentInstances(t *testing.T) {
+	t.Parallel()
+	// Connect doesn't reach the server, it is enough to build databases
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	require.NoError(t, err)
+
+	tenantA := InitMongo(client, client.Database("tenant_a"), &nopHook{name: "a"}, Options{}).(*Mongo)
+	tenantB := InitMongo(client, client.Database("tenant_b"), &nopHook{name: "b"}, Options{Transactional: true}).(*Mongo)
+
+	assert.Equal(t, "tenant_a", tenantA.Database.Name())
+	assert.Equal(t, "tenant_b", tenantB.Database.Name())
+	assert.Equal(t, "a", tenantA.hook.(*nopHook).name)
+	assert.Equal(t, "b", tenantB.hook.(*nopHook).name)
+	assert.False(t, tenantA.opts.Transactional)
+	assert.True(t, tenantB.opts.Transactional)
+}
+
+func TestModelID(t *testing.T) {
+	type model struct {
+		ID   int    `bson:"_id,omitempty"`
+		Name string `bson:"name"`
+	}
+	id, ok := modelID(&model{ID: 7, Name: "a"})
+	require.True(t, ok)
+	assert.Equal(t, int32(7), id.(bson.RawValue).Int32())
+
+	_, ok = modelID(&model{Name: "a"})
+	assert.False(t, ok, "an omitted _id is generated by the server")
+	_, ok = modelID(bson.M{"name": "a"})
+	assert.False(t, ok)
+}
+
+func TestUnchanged(t *testing.T) {
+	raw, err := bson.Marshal(bson.D{{Key: "_id", Value: "1"}, {Key: "name", Value: "a"}, {Key: "address", Value: bson.D{{Key: "city", Value: "Dhaka"}}}})
+	require.NoError(t, err)
+	guard, doc, err := unchanged(raw)
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"_id": "1", "name": "a", "address": bson.M{"city": "Dhaka"}}, doc)
+
+	// The guard compares the whole doc in its stored field order
+	assert.Equal(t, bson.M{
+		"_id": "1",
+		"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": bson.D{
+			{Key: "_id", Value: "1"}, {Key: "name", Value: "a"}, {Key: "address", Value: bson.D{{Key: "city", Value: "Dhaka"}}},
+		}}}},
+	}, guard)
+}
+
+func TestSetUpdate(t *testing.T) {
+	filter := bson.M{"_id": "1"}
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{"Partial document", bson.M{"name": "a"}},
+		{"Ordered partial document", bson.D{{Key: "name", Value: "a"}, {Key: "age", Value: 3}}},
+		{"Model", &struct {
+			Name string `bson:"name"`
+		}{Name: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, update, err := setUpdate(filter, tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, filter, query)
+			assert.Equal(t, bson.M{"$set": tt.data}, update, "only the fields of data are set")
+		})
+	}
+}
+
+func TestByIds(t *testing.T) {
+	oid := primitive.NewObjectID()
+	docs := []bson.M{{"_id": oid}, {"_id": int64(7)}, {"_id": "a"}, {"_id": bson.D{{Key: "k", Value: 1}}}}
+	assert.Equal(t, bson.M{"_id": bson.M{"$in": bson.A{oid, int64(7), "a", bson.D{{Key: "k", Value: 1}}}}}, byIds(docs),
+		"the ids are matched as stored")
+}
+
+func TestReadBackQuery(t *testing.T) {
+	type model struct {
+		ID   string `bson:"_id,omitempty"`
+		Name string `bson:"name"`
+	}
+	filter := bson.M{"name": "a"}
+	assert.Equal(t, bson.M{"_id": "1"}, readBackQuery(&model{Name: "a"}, filter, bson.M{"_id": "1", "name": "b"}),
+		"an updated doc is read by its _id")
+
+	query := readBackQuery(&model{ID: "2", Name: "a"}, filter, nil)
+	assert.Equal(t, "2", query.(bson.M)["_id"].(bson.RawValue).StringValue(), "an inserted doc is read by the _id of the model")
+	assert.Equal(t, filter, readBackQuery(bson.M{"name": "a"}, filter, nil), "else by filter")
+}
+
+func TestSoftDeleteUpdate(t *testing.T) {
+	deletedAt := primitive.NewDateTimeFromTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	state, update := softDeleteUpdate("soft_delete", deletedAt)
+	assert.Equal(t, bson.M{db.DeletedAt: nil}, state, "only docs that aren't soft-deleted are soft-deleted")
+	assert.Equal(t, bson.M{"$set": bson.M{db.DeletedAt: deletedAt}}, update)
+
+	state, update = softDeleteUpdate("restore", deletedAt)
+	assert.Equal(t, bson.M{db.DeletedAt: bson.M{"$ne": nil}}, state, "only soft-deleted docs are restored")
+	assert.Equal(t, bson.M{"$unset": bson.M{db.DeletedAt: ""}}, update)
+}
+
+func TestReadFilter(t *testing.T) {
+	d := &Mongo{opts: Options{SoftDelete: []string{"user"}}}
+	ctx := context.Background()
+	filter := bson.M{"name": "a"}
+	assert.Equal(t, map[string]interface{}{"$and": []interface{}{filter, map[string]interface{}{db.DeletedAt: nil}}},
+		d.readFilter(ctx, "user", filter), "soft-deleted docs are skipped")
+	assert.Equal(t, map[string]interface{}{db.DeletedAt: nil}, d.readFilter(ctx, "user", nil))
+	assert.Equal(t, filter, d.readFilter(db.WithDeleted(ctx), "user", filter), "unless the ctx includes them")
+	assert.Equal(t, filter, d.readFilter(ctx, "order", filter), "in soft-delete collections only")
+}
+
+func TestVersionedUpdate(t *testing.T) {
+	type account struct {
+		ID      string `bson:"_id"`
+		Balance int    `bson:"balance"`
+		Version int64  `bson:"version" gaudit:"version"`
+	}
+	filter := bson.M{"_id": "a1"}
+
+	query, update, err := setUpdate(filter, &account{ID: "a1", Balance: 20, Version: 3})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": bson.A{filter, bson.M{"version": int64(3)}}}, query, "only the doc at the version matches")
+	assert.Equal(t, bson.M{
+		"$set": bson.M{"_id": "a1", "balance": int32(20)},
+		"$inc": bson.M{"version": 1},
+	}, update, "the version is incremented, not set")
+
+	query, _, err = setUpdate(filter, &account{ID: "a1"})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": bson.A{filter, bson.M{"version": bson.M{"$in": bson.A{int64(0), nil}}}}}, query,
+		"a doc without version is at version 0")
+
+	query, _, err = setUpdate(nil, &account{ID: "a1", Version: 1})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"version": int64(1)}, query)
+}
@@ -0,0 +1,56 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/its-own/gaudit/db"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Server error codes of the failures translated to db errors
+const (
+	codeWriteConcernFailed        = 64
+	codeUnknownReplWriteConcern   = 79
+	codeUnsatisfiableWriteConcern = 100
+	codeDocumentValidationFailure = 121
+)
+
+// translate replaces *err, the failure of operation ops on col, by a *db.OpError matching the
+// db error describing it, so callers don't need the driver to tell failures apart
+func translate(err *error, col, ops string) {
+	*err = db.WrapOp(*err, col, ops, kindOf(*err))
+}
+
+// kindOf returns the db error describing a driver error, nil when none does
+func kindOf(err error) error {
+	var (
+		serverErr mongo.ServerError
+		writeErr  mongo.WriteException
+		bulkErr   mongo.BulkWriteException
+		cmdErr    mongo.CommandError
+		noEncoder bsoncodec.ErrNoEncoder
+		noDecoder bsoncodec.ErrNoDecoder
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, db.ErrNotFound):
+		return db.ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return db.ErrDuplicateKey
+	case mongo.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return db.ErrTimeout
+	case errors.As(err, &writeErr) && writeErr.WriteConcernError != nil,
+		errors.As(err, &bulkErr) && bulkErr.WriteConcernError != nil,
+		errors.As(err, &cmdErr) && (cmdErr.Code == codeWriteConcernFailed ||
+			cmdErr.Code == codeUnknownReplWriteConcern || cmdErr.Code == codeUnsatisfiableWriteConcern):
+		return db.ErrWriteConcern
+	case errors.As(err, &serverErr) && serverErr.HasErrorCode(codeDocumentValidationFailure),
+		errors.Is(err, mongo.ErrNilDocument), errors.Is(err, mongo.ErrEmptySlice):
+		return db.ErrInvalidData
+	case errors.As(err, &noEncoder), errors.As(err, &noDecoder):
+		return db.ErrUnsupportedType
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "no documents", err: mongo.ErrNoDocuments, want: db.ErrNotFound},
+		{name: "duplicate key", err: mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, want: db.ErrDuplicateKey},
+		{name: "bulk duplicate key", err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 11000}}}}, want: db.ErrDuplicateKey},
+		{name: "deadline", err: fmt.Errorf("find: %w", context.DeadlineExceeded), want: db.ErrTimeout},
+		{name: "max time", err: mongo.CommandError{Code: 50}, want: db.ErrTimeout},
+		{name: "write concern", err: mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}, want: db.ErrWriteConcern},
+		{name: "unsatisfiable write concern", err: mongo.CommandError{Code: 100}, want: db.ErrWriteConcern},
+		{name: "validation", err: mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}}, want: db.ErrInvalidData},
+		{name: "nil document", err: mongo.ErrNilDocument, want: db.ErrInvalidData},
+		{name: "no encoder", err: bsoncodec.ErrNoEncoder{Type: reflect.TypeOf(make(chan int))}, want: db.ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			translate(&err, "user", "insert")
+			assert.ErrorIs(t, err, tt.want)
+			assert.Contains(t, err.Error(), tt.err.Error(), "the driver error is kept")
+			var opErr *db.OpError
+			require.ErrorAs(t, err, &opErr)
+			assert.Equal(t, "user", opErr.Collection)
+			assert.Equal(t, "insert", opErr.Operation)
+		})
+	}
+}
+
+func TestTranslate_Unchanged(t *testing.T) {
+	var err error
+	translate(&err, "user", "insert")
+	assert.NoError(t, err)
+
+	for _, want := range []error{
+		&db.ConflictError{Collection: "user", Version: 2},
+		&db.OpError{Collection: "user", Operation: "update", Err: db.ErrNotFound},
+	} {
+		err := want
+		translate(&err, "user", "update")
+		assert.Equal(t, want, err)
+	}
+
+	err = errors.New("boom")
+	translate(&err, "user", "list")
+	assert.EqualError(t, err, "list on user: boom", "unknown failures get the collection and operation")
+}
+
+func TestTranslate_HookErrors(t *testing.T) {
+	for _, kind := range []error{db.ErrAborted, db.ErrAuditFailed} {
+		err := fmt.Errorf("%w: read only", kind)
+		translate(&err, "user", "update")
+		assert.ErrorIs(t, err, kind)
+		assert.EqualError(t, err, "update on user: "+kind.Error()+": read only")
+		var opErr *db.OpError
+		require.ErrorAs(t, err, &opErr)
+		assert.Equal(t, "user", opErr.Collection)
+		assert.Equal(t, "update", opErr.Operation)
+	}
+}
@@ -0,0 +1,107 @@
+package gaudit
+
+import (
+	"context"
+	"fmt"
+	"github.com/its-own/gaudit/db"
+	audit "github.com/its-own/gaudit/internal/audit_log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+)
+
+// Repo is the repository of the documents of type T stored in one collection, every write
+// goes through the audited connection. T is a struct type with an _id field, usually
+// embedding in.Inject.
+type Repo[T any] struct {
+	conn       db.NoSql
+	collection string
+}
+
+// NewRepo returns the repository of collection col on conn, a connection returned by Init or
+// InitMemory. T is registered for auditing, whether or not it embeds in.Inject.
+func NewRepo[T any](conn db.NoSql, col string) *Repo[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	audit.RegisterModel(t.PkgPath() + t.Name())
+	return &Repo[T]{conn: conn, collection: col}
+}
+
+// Create inserts doc. A zero ObjectID _id is generated and set on doc before the insert, other
+// _id types must be set by the caller: db.ErrInvalidData is returned when the database would
+// generate an id that can't be set on doc.
+func (r *Repo[T]) Create(ctx context.Context, doc *T) error {
+	id, omitEmpty, ok := idField(reflect.ValueOf(doc).Elem())
+	if !ok {
+		return fmt.Errorf("%w: %T has no _id field", db.ErrInvalidData, doc)
+	}
+	if id.IsZero() {
+		switch {
+		case id.Type() == reflect.TypeOf(primitive.ObjectID{}):
+			id.Set(reflect.ValueOf(primitive.NewObjectID()))
+		case omitEmpty:
+			return fmt.Errorf("%w: %T has no _id", db.ErrInvalidData, doc)
+		}
+	}
+	return r.conn.Insert(ctx, r.collection, doc)
+}
+
+// Get returns the doc whose _id is id, db.ErrNotFound when there is none
+func (r *Repo[T]) Get(ctx context.Context, id interface{}) (*T, error) {
+	var doc T
+	if err := r.conn.FindOne(ctx, r.collection, bson.M{"_id": id}, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Update sets the fields of doc on the doc whose _id is id, db.ErrNotFound when there is none
+func (r *Repo[T]) Update(ctx context.Context, id interface{}, doc *T) error {
+	return r.conn.Update(ctx, r.collection, bson.M{"_id": id}, doc)
+}
+
+// Delete deletes the doc whose _id is id and returns it, db.ErrNotFound when there is none.
+// In a soft-delete collection the doc is soft-deleted.
+func (r *Repo[T]) Delete(ctx context.Context, id interface{}) (*T, error) {
+	var doc T
+	if err := r.conn.FindOneAndDelete(ctx, r.collection, bson.M{"_id": id}, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// idField returns the _id field of v, a struct, and whether it is omitted when empty
+func idField(v reflect.Value) (reflect.Value, bool, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("bson"), ",")
+		if tag[0] == "_id" && f.IsExported() {
+			return v.Field(i), strings.Contains(f.Tag.Get("bson"), "omitempty"), true
+		}
+		if f.Anonymous && strings.Contains(f.Tag.Get("bson"), "inline") {
+			if id, omitEmpty, ok := idField(v.Field(i)); ok {
+				return id, omitEmpty, true
+			}
+		}
+	}
+	return reflect.Value{}, false, false
+}
+
+// List returns the docs matching filter with skip and limit, a limit of 0 means no limit
+func (r *Repo[T]) List(ctx context.Context, filter interface{}, skip, limit int64, sort ...interface{}) ([]T, error) {
+	var docs []T
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if err := r.conn.List(ctx, r.collection, filter, skip, limit, &docs, sort...); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
@@ -0,0 +1,80 @@
+package gaudit
+
+import (
+	"context"
+	"github.com/its-own/gaudit/db"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+// book doesn't embed in.Inject, NewRepo registers it
+type book struct {
+	ID    string `bson:"_id"`
+	Title string `bson:"title"`
+	Pages int    `bson:"pages"`
+}
+
+func TestRepo(t *testing.T) {
+	ctx := context.Background()
+	conn := InitMemory(&Config{})
+	books := NewRepo[book](conn, "book")
+
+	require.NoError(t, books.Create(ctx, &book{ID: "b1", Title: "Padma", Pages: 200}))
+	require.NoError(t, books.Create(ctx, &book{ID: "b2", Title: "Meghna", Pages: 120}))
+	require.NoError(t, books.Update(ctx, "b1", &book{ID: "b1", Title: "Padma", Pages: 210}))
+
+	got, err := books.Get(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, 210, got.Pages)
+	_, err = books.Get(ctx, "b3")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	list, err := books.List(ctx, nil, 0, 0, bson.M{"pages": 1})
+	require.NoError(t, err)
+	assert.Equal(t, []book{{ID: "b2", Title: "Meghna", Pages: 120}, {ID: "b1", Title: "Padma", Pages: 210}}, list)
+
+	deleted, err := books.Delete(ctx, "b2")
+	require.NoError(t, err)
+	assert.Equal(t, "Meghna", deleted.Title)
+	_, err = books.Delete(ctx, "b2")
+	assert.ErrorIs(t, err, db.ErrNotFound)
+
+	var logs []in.AuditLog
+	require.NoError(t, conn.List(ctx, "audit_logs", bson.M{"collection": "book"}, 0, 0, &logs, bson.M{"audit_created_at": 1}))
+	var ops []string
+	for _, log := range logs {
+		ops = append(ops, log.DocumentId+" "+log.Operation)
+	}
+	assert.ElementsMatch(t, []string{"b1 insert", "b2 insert", "b1 update", "b2 delete"}, ops)
+}
+
+type post struct {
+	in.Inject
+	ID   primitive.ObjectID `bson:"_id,omitempty"`
+	Text string             `bson:"text"`
+}
+
+type tag struct {
+	ID   string `bson:"_id,omitempty"`
+	Name string `bson:"name"`
+}
+
+func TestRepo_CreateIDs(t *testing.T) {
+	ctx := context.Background()
+	conn := InitMemory(&Config{})
+
+	posts := NewRepo[post](conn, "post")
+	p := &post{Text: "hello"}
+	require.NoError(t, posts.Create(ctx, p))
+	require.False(t, p.ID.IsZero(), "the generated _id is set on the doc")
+	got, err := posts.Get(ctx, p.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got.Text)
+
+	tags := NewRepo[tag](conn, "tag")
+	assert.ErrorIs(t, tags.Create(ctx, &tag{Name: "go"}), db.ErrInvalidData)
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a payload that could not be delivered
+type DeadLetter struct {
+	URL      string    `json:"url" bson:"url"`
+	Payload  []byte    `json:"payload" bson:"payload"`
+	Reason   string    `json:"reason" bson:"reason"`
+	FailedAt time.Time `json:"failed_at" bson:"failed_at"`
+}
+
+// DeadLetterStore keeps undelivered payloads so they can be inspected or replayed
+type DeadLetterStore interface {
+	Store(ctx context.Context, letter DeadLetter) error
+}
+
+// MemoryDeadLetter is an in-memory DeadLetterStore
+type MemoryDeadLetter struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func NewMemoryDeadLetter() *MemoryDeadLetter {
+	return &MemoryDeadLetter{}
+}
+
+func (m *MemoryDeadLetter) Store(_ context.Context, letter DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.letters = append(m.letters, letter)
+	return nil
+}
+
+// Letters returns a copy of the stored dead letters
+func (m *MemoryDeadLetter) Letters() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DeadLetter(nil), m.letters...)
+}
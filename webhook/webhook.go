@@ -0,0 +1,357 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/its-own/gaudit/in"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// List of errors
+var (
+	ErrQueueFull = errors.New("webhook: queue is full")
+	ErrClosed    = errors.New("webhook: dispatcher is closed")
+)
+
+// Header names set on every delivery
+const (
+	SignatureHeader = "X-Gaudit-Signature"
+	TimestampHeader = "X-Gaudit-Timestamp"
+)
+
+// Config holds the endpoint and delivery settings of a webhook Dispatcher.
+// Zero values fall back to the defaults documented on each field.
+type Config struct {
+	// URL of the receiving endpoint, required
+	URL string
+	// Secret used to sign every payload with HMAC-SHA256, payloads are sent unsigned if empty
+	Secret string
+	// Collections limits delivery to audit logs of these collections, all collections if empty
+	Collections []string
+	// Operations limits delivery to these operations (insert, update, ...), all operations if empty
+	Operations []string
+	// BatchSize is the maximum number of audit logs per request, 100 by default
+	BatchSize int
+	// FlushInterval is the longest time an audit log waits for its batch to fill up, 1s by default
+	FlushInterval time.Duration
+	// QueueSize is the number of audit logs buffered before they are dead-lettered, 1000 by default
+	QueueSize int
+	// MaxRetries is the number of retries after a failed delivery, 5 by default and none if negative
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, doubled on every retry, 500ms by default
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two retries, 30s by default
+	MaxBackoff time.Duration
+	// DeadLetter stores the batches that could not be delivered, kept in memory by default
+	DeadLetter DeadLetterStore
+	// Client sends the requests, a client with a 10s timeout by default
+	Client *http.Client
+	Logger *slog.Logger
+}
+
+// Payload is the JSON body posted to the endpoint
+type Payload struct {
+	SentAt time.Time     `json:"sent_at"`
+	Events []in.AuditLog `json:"events"`
+}
+
+// Dispatcher delivers audit logs to a webhook endpoint in batches.
+// It implements in.Sink, so it can be passed to gaudit.Config.Sinks.
+type Dispatcher struct {
+	cfg         Config
+	collections map[string]bool
+	operations  map[string]bool
+	queue       chan in.AuditLog
+	flush       chan chan struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+	// mu guards closed, Send holds it while queueing so no audit log is queued after Close
+	mu     sync.RWMutex
+	closed bool
+	// ctx is cancelled when Close gives up waiting, aborting the delivery in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New starts a Dispatcher for cfg, Close must be called to deliver the pending audit logs
+func New(cfg Config) (*Dispatcher, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook: url is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.DeadLetter == nil {
+		cfg.DeadLetter = NewMemoryDeadLetter()
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	d := &Dispatcher{
+		cfg:         cfg,
+		collections: toSet(cfg.Collections),
+		operations:  toSet(cfg.Operations),
+		queue:       make(chan in.AuditLog, cfg.QueueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.wg.Add(1)
+	go d.run()
+	return d, nil
+}
+
+// Send queues the audit logs that pass the filters, it never blocks the caller.
+// Audit logs that do not fit in the queue are dead-lettered with ErrQueueFull.
+func (d *Dispatcher) Send(ctx context.Context, logs []in.AuditLog) error {
+	overflow, err := d.enqueue(logs)
+	if err != nil {
+		return err
+	}
+	if len(overflow) > 0 {
+		return d.deadLetter(ctx, overflow, ErrQueueFull)
+	}
+	return nil
+}
+
+// enqueue queues the audit logs that pass the filters and returns those that do not fit
+func (d *Dispatcher) enqueue(logs []in.AuditLog) ([]in.AuditLog, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	var overflow []in.AuditLog
+	for _, log := range logs {
+		if !d.accept(log) {
+			continue
+		}
+		select {
+		case d.queue <- log:
+		default:
+			overflow = append(overflow, log)
+		}
+	}
+	return overflow, nil
+}
+
+// Flush delivers every queued audit log and waits until it is done
+func (d *Dispatcher) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case d.flush <- ack:
+	case <-d.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close delivers the pending audit logs and stops the dispatcher. When ctx is done first, the
+// delivery in progress is aborted and the audit logs left are dead-lettered in the background.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.closeOnce.Do(func() { close(d.done) })
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		return ctx.Err()
+	}
+}
+
+// accept reports whether log passes the collection and operation filters
+func (d *Dispatcher) accept(log in.AuditLog) bool {
+	if len(d.collections) > 0 && !d.collections[log.Collection] {
+		return false
+	}
+	if len(d.operations) > 0 && !d.operations[log.Operation] {
+		return false
+	}
+	return true
+}
+
+// run batches queued audit logs until the dispatcher is closed
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]in.AuditLog, 0, d.cfg.BatchSize)
+	deliver := func() {
+		if len(batch) == 0 {
+			return
+		}
+		d.deliver(batch)
+		batch = make([]in.AuditLog, 0, d.cfg.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case log := <-d.queue:
+				batch = append(batch, log)
+				if len(batch) >= d.cfg.BatchSize {
+					deliver()
+				}
+			default:
+				deliver()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case log := <-d.queue:
+			batch = append(batch, log)
+			if len(batch) >= d.cfg.BatchSize {
+				deliver()
+			}
+		case <-ticker.C:
+			deliver()
+		case ack := <-d.flush:
+			drain()
+			close(ack)
+		case <-d.done:
+			drain()
+			return
+		}
+	}
+}
+
+// deliver posts a batch, retrying with exponential backoff, and dead-letters it when all attempts
+// fail or the dispatcher is aborted
+func (d *Dispatcher) deliver(batch []in.AuditLog) {
+	body, err := json.Marshal(Payload{SentAt: time.Now().UTC(), Events: batch})
+	if err != nil {
+		_ = d.deadLetter(context.Background(), batch, err)
+		return
+	}
+
+	backoff := d.cfg.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(d.ctx, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.cfg.MaxRetries || d.ctx.Err() != nil {
+			d.cfg.Logger.Error("webhook delivery failed", "url", d.cfg.URL, "attempts", attempt+1, "error", err)
+			_ = d.storeDeadLetter(context.Background(), body, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+		}
+		backoff *= 2
+		if backoff > d.cfg.MaxBackoff {
+			backoff = d.cfg.MaxBackoff
+		}
+	}
+}
+
+// post sends a single request and reports whether a failure is worth retrying
+func (d *Dispatcher) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if d.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	// Server errors, rate limiting and timeouts are transient, other client errors are not
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
+
+// deadLetter encodes logs as a payload and stores it in the dead-letter store
+func (d *Dispatcher) deadLetter(ctx context.Context, logs []in.AuditLog, reason error) error {
+	body, err := json.Marshal(Payload{SentAt: time.Now().UTC(), Events: logs})
+	if err != nil {
+		return err
+	}
+	if err := d.storeDeadLetter(ctx, body, reason); err != nil {
+		return err
+	}
+	return reason
+}
+
+func (d *Dispatcher) storeDeadLetter(ctx context.Context, body []byte, reason error) error {
+	err := d.cfg.DeadLetter.Store(ctx, DeadLetter{
+		URL:      d.cfg.URL,
+		Payload:  body,
+		Reason:   reason.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		d.cfg.Logger.Error("failed to store webhook dead letter", "url", d.cfg.URL, "error", err)
+	}
+	return err
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/its-own/gaudit/in"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver records the payloads posted to a test server
+type receiver struct {
+	mu       sync.Mutex
+	payloads []Payload
+	headers  []http.Header
+}
+
+func (r *receiver) handler(t *testing.T, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		if secret != "" && !Verify(secret, req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var p Payload
+		require.NoError(t, json.Unmarshal(body, &p))
+		r.mu.Lock()
+		r.payloads = append(r.payloads, p)
+		r.headers = append(r.headers, req.Header.Clone())
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (r *receiver) events() []in.AuditLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []in.AuditLog
+	for _, p := range r.payloads {
+		events = append(events, p.Events...)
+	}
+	return events
+}
+
+func newLog(col, ops, docId string) in.AuditLog {
+	return in.AuditLog{Collection: col, Operation: ops, DocumentId: docId}
+}
+
+func TestDispatcher_SignedBatches(t *testing.T) {
+	rec := &receiver{}
+	srv := httptest.NewServer(rec.handler(t, "s3cret"))
+	defer srv.Close()
+
+	d, err := New(Config{URL: srv.URL, Secret: "s3cret", BatchSize: 2, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, []in.AuditLog{newLog("user", "insert", "1"), newLog("user", "update", "1"), newLog("user", "update", "2")}))
+	require.NoError(t, d.Close(ctx))
+
+	assert.Len(t, rec.payloads, 2, "a full batch and the remainder flushed on close")
+	assert.Len(t, rec.payloads[0].Events, 2)
+	assert.Len(t, rec.payloads[1].Events, 1)
+	assert.Equal(t, "application/json", rec.headers[0].Get("Content-Type"))
+	assert.Equal(t, []string{"1", "1", "2"}, documentIds(rec.events()))
+}
+
+func TestDispatcher_Filters(t *testing.T) {
+	rec := &receiver{}
+	srv := httptest.NewServer(rec.handler(t, ""))
+	defer srv.Close()
+
+	d, err := New(Config{URL: srv.URL, Collections: []string{"user"}, Operations: []string{"update"}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, []in.AuditLog{
+		newLog("user", "insert", "1"),
+		newLog("user", "update", "2"),
+		newLog("session", "update", "3"),
+	}))
+	require.NoError(t, d.Flush(ctx))
+	assert.Equal(t, []string{"2"}, documentIds(rec.events()))
+	require.NoError(t, d.Close(ctx))
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	rec := &receiver{}
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rec.handler(t, "")(w, req)
+	}))
+	defer srv.Close()
+
+	dl := NewMemoryDeadLetter()
+	d, err := New(Config{URL: srv.URL, InitialBackoff: time.Millisecond, DeadLetter: dl})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, []in.AuditLog{newLog("user", "update", "1")}))
+	require.NoError(t, d.Close(ctx))
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"1"}, documentIds(rec.events()))
+	assert.Empty(t, dl.Letters())
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dl := NewMemoryDeadLetter()
+	d, err := New(Config{URL: srv.URL, MaxRetries: 2, InitialBackoff: time.Millisecond, DeadLetter: dl})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, []in.AuditLog{newLog("user", "update", "1")}))
+	require.NoError(t, d.Close(ctx))
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls), "first attempt and two retries")
+	letters := dl.Letters()
+	require.Len(t, letters, 1)
+	assert.Equal(t, srv.URL, letters[0].URL)
+	var p Payload
+	require.NoError(t, json.Unmarshal(letters[0].Payload, &p))
+	assert.Equal(t, []string{"1"}, documentIds(p.Events))
+}
+
+func TestDispatcher_ClientErrorIsNotRetried(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	dl := NewMemoryDeadLetter()
+	d, err := New(Config{URL: srv.URL, InitialBackoff: time.Millisecond, DeadLetter: dl})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, []in.AuditLog{newLog("user", "update", "1")}))
+	require.NoError(t, d.Close(ctx))
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	assert.Len(t, dl.Letters(), 1)
+}
+
+func TestDispatcher_SendAfterClose(t *testing.T) {
+	d, err := New(Config{URL: "http://127.0.0.1:0"})
+	require.NoError(t, err)
+	require.NoError(t, d.Close(context.Background()))
+	assert.ErrorIs(t, d.Send(context.Background(), []in.AuditLog{newLog("user", "update", "1")}), ErrClosed)
+}
+
+func TestDispatcher_CloseAbortsBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	dl := NewMemoryDeadLetter()
+	d, err := New(Config{URL: srv.URL, InitialBackoff: time.Hour, DeadLetter: dl})
+	require.NoError(t, err)
+	require.NoError(t, d.Send(context.Background(), []in.AuditLog{newLog("user", "update", "1")}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+	assert.Eventually(t, func() bool { return len(dl.Letters()) == 1 }, time.Second, 10*time.Millisecond,
+		"the batch waiting for a retry is dead-lettered")
+}
+
+func TestDispatcher_SendDuringClose(t *testing.T) {
+	rec := &receiver{}
+	srv := httptest.NewServer(rec.handler(t, ""))
+	defer srv.Close()
+
+	d, err := New(Config{URL: srv.URL, QueueSize: 100000})
+	require.NoError(t, err)
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d.Send(context.Background(), []in.AuditLog{newLog("user", "update", "1")}) == nil {
+				sent.Add(1)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, d.Close(context.Background()))
+	wg.Wait()
+	assert.Len(t, rec.events(), int(sent.Load()), "every accepted audit log is delivered")
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"events":[]}`)
+	sig := Sign("key", "1700000000", body)
+	assert.True(t, Verify("key", "1700000000", body, sig))
+	assert.False(t, Verify("other", "1700000000", body, sig))
+	assert.False(t, Verify("key", "1700000001", body, sig))
+	assert.False(t, Verify("key", "1700000000", []byte(`{}`), sig))
+}
+
+func documentIds(logs []in.AuditLog) []string {
+	ids := make([]string, 0, len(logs))
+	for _, l := range logs {
+		ids = append(ids, l.DocumentId)
+	}
+	return ids
+}